@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"jolli.ai/param/serverconfig"
+)
+
+const proxyDialTimeout = 5 * time.Second
+
+// nginxReloadGrace is how long to give nginx to start workers with the new
+// upstream before the old app is stopped.
+var nginxReloadGrace = 2 * time.Second
+
+// blueGreen runs a server's app on two alternating ports, so a new install
+// can start and pass its health check while the old one keeps serving, and
+// only then takes over the traffic.
+type blueGreen struct {
+	ports [2]int
+	sw    trafficSwitch
+}
+
+// trafficSwitch moves traffic between the blue and green apps.
+type trafficSwitch interface {
+	// route sends new connections to the app listening on port.
+	route(port int) error
+	Close() error
+}
+
+// loadBlueGreen reads a server's blue/green settings from its .config. It
+// returns nil if BLUE_GREEN isn't set.
+func loadBlueGreen(cfg *serverconfig.ServerConfig) (*blueGreen, error) {
+	mode := cfg.Get("BLUE_GREEN")
+	if mode == "" {
+		return nil, nil
+	}
+
+	bg := &blueGreen{}
+	for i, key := range []string{"BLUE_PORT", "GREEN_PORT"} {
+		port, err := cfg.Int(key, 0)
+		if err != nil {
+			return nil, err
+		}
+		if port == 0 {
+			return nil, fmt.Errorf("BLUE_GREEN needs %s", key)
+		}
+		bg.ports[i] = port
+	}
+	if bg.ports[0] == bg.ports[1] {
+		return nil, errors.New("BLUE_PORT and GREEN_PORT must differ")
+	}
+
+	switch mode {
+	case "proxy":
+		addr := cfg.Get("PROXY_LISTEN")
+		if addr == "" {
+			return nil, errors.New("BLUE_GREEN=proxy needs PROXY_LISTEN")
+		}
+		p, err := listenProxy(addr)
+		if err != nil {
+			return nil, err
+		}
+		bg.sw = p
+	case "nginx":
+		path := cfg.Get("NGINX_UPSTREAM")
+		if path == "" {
+			return nil, errors.New("BLUE_GREEN=nginx needs NGINX_UPSTREAM")
+		}
+		nginx := cfg.Get("NGINX")
+		if nginx == "" {
+			nginx = "nginx"
+		}
+		bg.sw = &nginxUpstream{path: path, nginx: nginx}
+	default:
+		return nil, fmt.Errorf("BLUE_GREEN must be proxy or nginx, got %q", mode)
+	}
+	return bg, nil
+}
+
+// other returns the port the next app should listen on while the app on port
+// is running.
+func (bg *blueGreen) other(port int) int {
+	if port == bg.ports[0] {
+		return bg.ports[1]
+	}
+	return bg.ports[0]
+}
+
+// replace starts the current install next to old on the other port, waits
+// for it to become ready, moves traffic to it and stops old. If the new app
+// doesn't come up, old keeps serving and is returned instead.
+func (r *runner) replace(ctx context.Context, old *app, signals chan os.Signal) *app {
+	oldPID := old.cmd.Process.Pid
+	a, err := r.start(ctx, r.bg.other(old.port), signals)
+	if err != nil {
+		r.log.marker("new app did not start: %v; keeping pid %d", err, oldPID)
+		return old
+	}
+
+	err = r.waitReady(ctx, a, signals)
+	if err == nil {
+		err = r.bg.sw.route(a.port)
+	}
+	if err != nil {
+		r.stop(a)
+		r.log.marker("new app failed: %v; keeping pid %d", err, oldPID)
+		return old
+	}
+
+	r.activate(a)
+	r.log.marker("traffic moved to port %d", a.port)
+	r.stop(old)
+	return a
+}
+
+// waitReady polls a newly started app until it answers the server's
+// HEALTH_URL on its own port, or accepts connections if there is no
+// HEALTH_URL. A signal arriving meanwhile gives up on the app and is left
+// for the supervisor to handle.
+func (r *runner) waitReady(ctx context.Context, a *app, signals chan os.Signal) error {
+	cfg, err := serverconfig.Load(r.dir)
+	if err != nil {
+		return err
+	}
+	timeout, err := cfg.Duration("HEALTH_TIMEOUT", defaultHealthTimeout)
+	if err != nil {
+		return err
+	}
+	healthURL, err := portURL(cfg.Get("HEALTH_URL"), a.port)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: healthRequestTimeout}
+	check := func() error {
+		if healthURL != "" {
+			return probe(ctx, client, healthURL)
+		}
+		conn, err := net.DialTimeout("tcp", localAddr(a.port), healthRequestTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	deadline := time.After(timeout)
+	for {
+		last := check()
+		if last == nil {
+			return nil
+		}
+
+		select {
+		case <-a.done:
+			return fmt.Errorf("app exited: %v", exitStatus(a.err))
+		case sig := <-signals:
+			signals <- sig
+			return fmt.Errorf("interrupted by %v", sig)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("not healthy after %s: %w", timeout, last)
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// portURL points a health check URL at the app listening on port, rather
+// than at whatever is in front of it.
+func portURL(rawURL string, port int) (string, error) {
+	if rawURL == "" {
+		return "", nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("HEALTH_URL: %w", err)
+	}
+	u.Host = localAddr(port)
+	return u.String(), nil
+}
+
+func localAddr(port int) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
+
+// tcpProxy forwards connections on its listen address to whichever local
+// port it was last routed to. Connections already open stay with the app
+// they were made to.
+type tcpProxy struct {
+	ln   net.Listener
+	port atomic.Int64
+}
+
+func listenProxy(addr string) (*tcpProxy, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &tcpProxy{ln: ln}
+	go p.serve()
+	return p, nil
+}
+
+func (p *tcpProxy) route(port int) error {
+	p.port.Store(int64(port))
+	return nil
+}
+
+func (p *tcpProxy) Close() error {
+	return p.ln.Close()
+}
+
+func (p *tcpProxy) serve() {
+	for {
+		conn, err := p.ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("proxy: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go p.forward(conn)
+	}
+}
+
+func (p *tcpProxy) forward(conn net.Conn) {
+	defer conn.Close()
+
+	port := int(p.port.Load())
+	if port == 0 {
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", localAddr(port), proxyDialTimeout)
+	if err != nil {
+		log.Printf("proxy: %v", err)
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstream, conn)
+		closeWrite(upstream)
+		close(done)
+	}()
+	io.Copy(conn, upstream)
+	closeWrite(conn)
+	<-done
+}
+
+// closeWrite passes on the end of one direction of a proxied connection
+// while leaving the other direction open.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	}
+}
+
+// nginxUpstream routes traffic by rewriting a file included in an nginx
+// upstream block and reloading nginx.
+type nginxUpstream struct {
+	path  string
+	nginx string
+}
+
+func (n *nginxUpstream) route(port int) error {
+	data := fmt.Sprintf("# Written by param run.\nserver %s;\n", localAddr(port))
+	if err := writeFileAtomic(n.path, []byte(data), 0644); err != nil {
+		return err
+	}
+	out, err := exec.Command(n.nginx, "-s", "reload").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("reloading nginx: %w: %s", err, bytes.TrimSpace(out))
+	}
+	time.Sleep(nginxReloadGrace)
+	return nil
+}
+
+func (n *nginxUpstream) Close() error {
+	return nil
+}
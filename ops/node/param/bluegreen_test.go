@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backend starts an httptest server that answers every request with its
+// name, and returns its port.
+func backend(t *testing.T, name string) int {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// proxyGet sends a GET over conn and returns the body of the response.
+func proxyGet(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: app\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// served returns the body a new connection through the proxy gets.
+func served(t *testing.T, p *tcpProxy) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", p.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return proxyGet(t, conn)
+}
+
+func TestProxyRoutes(t *testing.T) {
+	bluePort := backend(t, "blue")
+	greenPort := backend(t, "green")
+	p, err := listenProxy("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.route(bluePort)
+	open, err := net.Dial("tcp", p.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	if got := proxyGet(t, open); got != "blue" {
+		t.Fatalf("got %q before routing to green, want blue", got)
+	}
+
+	p.route(greenPort)
+	if got := served(t, p); got != "green" {
+		t.Errorf("a new connection got %q, want green", got)
+	}
+	if got := proxyGet(t, open); got != "blue" {
+		t.Errorf("an open connection got %q, want it to stay with blue", got)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	bluePort := backend(t, "blue")
+	greenPort := backend(t, "green")
+	p, err := listenProxy("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The apps only sleep; the backends listening on their ports stand in
+	// for what they would serve.
+	r := newTestRunner(t, "START='sleep 60'\nHEALTH_TIMEOUT=1s\n")
+	r.bg = &blueGreen{ports: [2]int{bluePort, greenPort}, sw: p}
+	signals := make(chan os.Signal, 1)
+	ctx := context.Background()
+
+	old, err := r.start(ctx, r.firstPort(), signals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.serve(old); err != nil {
+		t.Fatal(err)
+	}
+	if got := served(t, p); got != "blue" {
+		t.Fatalf("got %q from the first app, want blue", got)
+	}
+
+	a := r.replace(ctx, old, signals)
+	defer r.stop(a)
+	if a == old || a.port != greenPort {
+		t.Fatalf("replaced the app on port %d with one on port %d, want %d", old.port, a.port, greenPort)
+	}
+	if got := served(t, p); got != "green" {
+		t.Errorf("got %q after replacing, want green", got)
+	}
+	if alive(old.cmd.Process.Pid) {
+		t.Error("the old app is still running")
+	}
+	if pid := appPID(r.dir); pid != a.cmd.Process.Pid {
+		t.Errorf("app.pid names %d, want the new app's %d", pid, a.cmd.Process.Pid)
+	}
+}
+
+func TestReplaceKeepsOldAppWhenNewOneFails(t *testing.T) {
+	bluePort := backend(t, "blue")
+	// Nothing listens on the green port, so the new app never becomes ready.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	greenPort := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	p, err := listenProxy("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	r := newTestRunner(t, "START='sleep 60'\nHEALTH_TIMEOUT=1s\n")
+	r.bg = &blueGreen{ports: [2]int{bluePort, greenPort}, sw: p}
+	signals := make(chan os.Signal, 1)
+	ctx := context.Background()
+
+	old, err := r.start(ctx, r.firstPort(), signals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.stop(old)
+	if err := r.serve(old); err != nil {
+		t.Fatal(err)
+	}
+
+	if a := r.replace(ctx, old, signals); a != old {
+		r.stop(a)
+		t.Fatalf("replaced the app with one on port %d that never became ready", a.port)
+	}
+	if got := served(t, p); got != "blue" {
+		t.Errorf("got %q after a failed replace, want blue", got)
+	}
+	if !alive(old.cmd.Process.Pid) {
+		t.Error("the old app was stopped")
+	}
+	if pid := appPID(r.dir); pid != old.cmd.Process.Pid {
+		t.Errorf("app.pid names %d, want the old app's %d", pid, old.cmd.Process.Pid)
+	}
+	data, _ := os.ReadFile(filepath.Join(r.dir, logFile))
+	if !strings.Contains(string(data), "new app failed: not healthy after 1s") {
+		t.Errorf("node.log doesn't record the failed replace:\n%s", data)
+	}
+}
+
+func TestNginxUpstream(t *testing.T) {
+	defer func(grace time.Duration) { nginxReloadGrace = grace }(nginxReloadGrace)
+	nginxReloadGrace = 0
+
+	// A stand-in for nginx that records its arguments and the upstream file
+	// as it finds it when told to reload.
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "upstream.conf")
+	reloaded := filepath.Join(dir, "reloaded")
+	nginx := filepath.Join(dir, "nginx")
+	os.WriteFile(nginx, []byte(fmt.Sprintf("#!/bin/sh\necho \"$@\" > %q\ncat %q >> %q\n", reloaded, upstream, reloaded)), 0755)
+	os.WriteFile(upstream, []byte("server 127.0.0.1:1;\n"), 0644)
+	before, err := os.Stat(upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &nginxUpstream{path: upstream, nginx: nginx}
+	if err := n.route(8035); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Written by param run.\nserver 127.0.0.1:8035;\n"
+	if data, _ := os.ReadFile(reloaded); string(data) != "-s reload\n"+want {
+		t.Errorf("nginx was run as, and found the upstream file holding:\n%s", data)
+	}
+	// The file was replaced by a rename, never rewritten in place where
+	// nginx could read it half written.
+	after, err := os.Stat(upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("the upstream file was rewritten in place")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("left %d files behind, want only nginx, reloaded and upstream.conf", len(entries))
+	}
+
+	os.WriteFile(nginx, []byte("#!/bin/sh\necho 'invalid upstream' >&2\nexit 1\n"), 0755)
+	if err := n.route(8036); err == nil || !strings.Contains(err.Error(), "invalid upstream") {
+		t.Errorf("got %v, want nginx's error", err)
+	}
+}
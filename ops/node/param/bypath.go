@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// envVar is a parameter renamed to the environment variable it maps to.
+type envVar struct {
+	Name  string
+	Value string
+	// Param is the parameter the variable came from, if any.
+	Param *types.Parameter
+}
+
+// getByPath prints every parameter below a path, by default as NAME=value
+// lines.
+func getByPath(ctx context.Context, args []string) error {
+	const getByPathUsage = "param get-by-path [--decrypt=false] [--output raw|json|shell|dotenv] [--region <region>] [--fallback-regions <region>,...] [--max-stale <duration>] [<region>] <path>"
+
+	fs := newFlagSet("get-by-path")
+	decrypt := fs.Bool("decrypt", true, "decrypt SecureString values")
+	output := outputFlag(fs, outputRaw)
+	region := fs.String("region", "", "AWS region")
+	fallbacks := fallbackFlag(fs)
+	maxStale := cacheFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return usageError(getByPathUsage)
+	}
+	path, err := regionArg(fs, region)
+	if err != nil {
+		return usageError(getByPathUsage)
+	}
+
+	ssmSvcs, err := newSSMClients(ctx, *region, *fallbacks)
+	if err != nil {
+		return err
+	}
+
+	vars, err := cachedEnvVarsByPath(ctx, openCache(*maxStale), ssmSvcs, path, *decrypt)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case outputJSON:
+		records := make([]paramJSON, len(vars))
+		for i, v := range vars {
+			records[i] = newParamJSON(*v.Param)
+			records[i].EnvName = v.Name
+			records[i].Value = v.Value
+		}
+		return writeJSON(os.Stdout, records)
+	case outputShell, outputDotenv:
+		return writeEnvVars(os.Stdout, vars, *output)
+	}
+	for _, v := range vars {
+		fmt.Printf("%s=%s\n", v.Name, v.Value)
+	}
+	return nil
+}
+
+// envVarsByPath pages through every parameter below path, recursively, and
+// returns them sorted by environment variable name. Names and values are
+// derived the same way as the backend's ParameterStoreLoader so both agree on
+// what a given parameter is called.
+func envVarsByPath(ctx context.Context, ssmSvc *ssm.Client, path string, decrypt bool) ([]envVar, error) {
+	prefix := pathPrefix(path)
+	values := map[string]envVar{}
+
+	paginator := ssm.NewGetParametersByPathPaginator(ssmSvc, &ssm.GetParametersByPathInput{
+		Path:           aws.String(prefix),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(decrypt),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting parameters by path: %w", err)
+		}
+
+		for _, p := range page.Parameters {
+			if err := checkDecrypted(&p, decrypt); err != nil {
+				return nil, err
+			}
+			if p.Name == nil || p.Value == nil || *p.Value == "" {
+				continue
+			}
+
+			name, ok := strings.CutPrefix(*p.Name, prefix)
+			if !ok {
+				return nil, fmt.Errorf("parameter %q does not start with expected prefix %q", *p.Name, prefix)
+			}
+
+			envName := pathToEnvVarName(name)
+			values[envName] = envVar{Name: envName, Value: strings.TrimSpace(*p.Value), Param: &p}
+		}
+	}
+
+	vars := make([]envVar, 0, len(values))
+	for _, v := range values {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars, nil
+}
+
+// pathPrefix normalizes a parameter path to begin and end with a slash.
+func pathPrefix(path string) string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return "/"
+	}
+	return "/" + trimmed + "/"
+}
+
+// pathToEnvVarName converts a parameter path suffix from kebab-case with
+// slashes to UPPER_SNAKE_CASE, e.g. "github/apps/info" -> "GITHUB_APPS_INFO".
+func pathToEnvVarName(suffix string) string {
+	parts := strings.Split(suffix, "/")
+	for i, part := range parts {
+		parts[i] = strings.ToUpper(strings.ReplaceAll(part, "-", "_"))
+	}
+	return strings.Join(parts, "_")
+}
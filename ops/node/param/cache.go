@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	// defaultMaxStale is how old a cached value may be and still stand in for
+	// a live read that failed.
+	defaultMaxStale = 7 * 24 * time.Hour
+	// cacheKeyFile holds the key the cache is encrypted with.
+	cacheKeyFile = "key"
+	// cacheRefresh is how often an unchanged value is written back to the
+	// cache, so the sync daemon doesn't rewrite it on every poll.
+	cacheRefresh = time.Minute
+)
+
+// paramCache keeps the last value of each read on disk, so a node that
+// reboots during an SSM outage can still start its apps. A nil cache caches
+// nothing.
+//
+// Entries are encrypted with AES-GCM under a key stored alongside them, and
+// the directory is readable only by its owner. Anyone who can read the
+// directory, such as the same user or root, can read the key and so every
+// entry: the encryption only keeps an entry that is copied on its own, as
+// by a backup, from being read, and stops one entry being swapped for
+// another. Because of that, decrypted SecureString values are not cached
+// unless PARAM_CACHE_SECRETS is set.
+type paramCache struct {
+	dir      string
+	maxStale time.Duration
+	// secrets allows decrypted SecureString values to be cached.
+	secrets bool
+
+	key     []byte
+	written map[string]cacheEntry
+}
+
+// cacheEntry is one cached read as stored on disk, before encryption.
+type cacheEntry struct {
+	Key      string          `json:"key"`
+	StoredAt time.Time       `json:"storedAt"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// cacheFlag registers --max-stale on a command's flag set.
+func cacheFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("max-stale", defaultMaxStale, "oldest cached value to use when SSM can't be reached; 0 never uses the cache")
+}
+
+// openCache returns the cache in PARAM_CACHE_DIR, or in param below the
+// user's cache directory, caching decrypted SecureString values only if
+// PARAM_CACHE_SECRETS is true. A cache that can't be set up is reported and
+// disabled rather than failing the read it would have backed.
+func openCache(maxStale time.Duration) *paramCache {
+	dir := os.Getenv("PARAM_CACHE_DIR")
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			log.Printf("warning: cache disabled: %v", err)
+			return nil
+		}
+		dir = filepath.Join(userDir, "param")
+	}
+
+	var secrets bool
+	if v := os.Getenv("PARAM_CACHE_SECRETS"); v != "" {
+		var err error
+		if secrets, err = strconv.ParseBool(v); err != nil {
+			log.Printf("warning: not caching SecureString values: PARAM_CACHE_SECRETS is %q, not true or false", v)
+		}
+	}
+
+	key, err := loadCacheKey(dir)
+	if err != nil {
+		log.Printf("warning: cache disabled: %v", err)
+		return nil
+	}
+	return &paramCache{dir: dir, maxStale: maxStale, secrets: secrets, key: key, written: map[string]cacheEntry{}}
+}
+
+// loadCacheKey reads the cache's key, creating the directory and a random
+// key the first time.
+func loadCacheKey(dir string) ([]byte, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, cacheKeyFile)
+	key, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		key = make([]byte, 32)
+		rand.Read(key)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			// Another param created it first.
+			return loadCacheKey(dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(key); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return key, f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%s is not a 256-bit key", path)
+	}
+	return key, nil
+}
+
+// cachedRead returns fetch's result and caches it under key. If fetch fails
+// with a transient error, the cached value is returned instead, provided it
+// is no older than the cache's max staleness, and a warning saying how stale
+// it is goes to stderr. Any other error, such as the parameter no longer
+// existing, is returned as is. A value that secret reports as holding a
+// decrypted SecureString is neither cached nor answered from the cache
+// unless the cache allows secrets, and an earlier entry for it is removed.
+func cachedRead[T any](c *paramCache, key string, secret func(T) bool, fetch func() (T, error)) (T, error) {
+	value, err := fetch()
+	if c == nil {
+		return value, err
+	}
+	if err == nil {
+		if secret(value) && !c.secrets {
+			if err := c.remove(key); err != nil {
+				log.Printf("warning: removing cached %s: %v", key, err)
+			}
+			return value, nil
+		}
+		if err := c.store(key, value); err != nil {
+			log.Printf("warning: caching %s: %v", key, err)
+		}
+		return value, nil
+	}
+	if classify(err) != classTransient || c.maxStale <= 0 {
+		return value, err
+	}
+
+	entry, loadErr := c.load(key)
+	if loadErr != nil {
+		if !errors.Is(loadErr, fs.ErrNotExist) {
+			log.Printf("warning: reading cached %s: %v", key, loadErr)
+		}
+		return value, err
+	}
+	age := time.Since(entry.StoredAt)
+	if age > c.maxStale {
+		return value, fmt.Errorf("%w; the cached value is %s old, more than --max-stale %s", err, age.Round(time.Second), c.maxStale)
+	}
+	var cached T
+	if err := json.Unmarshal(entry.Value, &cached); err != nil {
+		log.Printf("warning: reading cached %s: %v", key, err)
+		return value, err
+	}
+	if secret(cached) && !c.secrets {
+		return value, err
+	}
+	log.Printf("warning: using a stale cached value of %s from %s ago: %v", key, age.Round(time.Second), err)
+	return cached, nil
+}
+
+// store writes value to the cache unless it already holds the same value
+// written less than cacheRefresh ago.
+func (c *paramCache) store(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if last, ok := c.written[key]; ok && string(last.Value) == string(data) && now.Sub(last.StoredAt) < cacheRefresh {
+		return nil
+	}
+
+	entry := cacheEntry{Key: key, StoredAt: now, Value: data}
+	plaintext, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	gcm, err := c.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(key))
+	if err := writeFileAtomic(c.path(key), sealed, 0600); err != nil {
+		return err
+	}
+	c.written[key] = entry
+	return nil
+}
+
+// remove deletes the cached entry for key, if there is one.
+func (c *paramCache) remove(key string) error {
+	delete(c.written, key)
+	if err := os.Remove(c.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// load reads and decrypts the cached entry for key.
+func (c *paramCache) load(key string) (cacheEntry, error) {
+	var entry cacheEntry
+	sealed, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return entry, err
+	}
+	gcm, err := c.cipher()
+	if err != nil {
+		return entry, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return entry, errors.New("cache entry is truncated")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(key))
+	if err != nil {
+		return entry, fmt.Errorf("decrypting cache entry: %w", err)
+	}
+	if err := json.Unmarshal(plaintext, &entry); err != nil {
+		return entry, err
+	}
+	if entry.Key != key {
+		return entry, fmt.Errorf("cache entry is for %s", entry.Key)
+	}
+	return entry, nil
+}
+
+func (c *paramCache) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// path names each entry after a hash of its key, so the cache directory
+// doesn't list which parameters it holds.
+func (c *paramCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// cacheKey identifies a read by the command that made it and its
+// arguments.
+func cacheKey(parts ...string) string {
+	return strings.Join(parts, " ")
+}
+
+// cachedParameter is getParameter backed by c, read from the first of
+// clients' regions that answers. Entries are keyed by the primary region.
+func cachedParameter(ctx context.Context, c *paramCache, clients []*ssm.Client, name string, decrypt bool) (*types.Parameter, error) {
+	key := cacheKey("get", clients[0].Options().Region, name, strconv.FormatBool(decrypt))
+	secret := func(p *types.Parameter) bool {
+		return decrypt && p.Type == types.ParameterTypeSecureString
+	}
+	return cachedRead(c, key, secret, func() (*types.Parameter, error) {
+		return readWithFallback(ctx, clients, func(ctx context.Context, ssmSvc *ssm.Client) (*types.Parameter, error) {
+			return getParameter(ctx, ssmSvc, name, decrypt)
+		})
+	})
+}
+
+// cachedEnvVarsByPath is envVarsByPath backed by c, read the same way as
+// cachedParameter.
+func cachedEnvVarsByPath(ctx context.Context, c *paramCache, clients []*ssm.Client, path string, decrypt bool) ([]envVar, error) {
+	key := cacheKey("get-by-path", clients[0].Options().Region, path, strconv.FormatBool(decrypt))
+	secret := func(vars []envVar) bool {
+		return decrypt && slices.ContainsFunc(vars, func(v envVar) bool {
+			return v.Param != nil && v.Param.Type == types.ParameterTypeSecureString
+		})
+	}
+	return cachedRead(c, key, secret, func() ([]envVar, error) {
+		return readWithFallback(ctx, clients, func(ctx context.Context, ssmSvc *ssm.Client) ([]envVar, error) {
+			return envVarsByPath(ctx, ssmSvc, path, decrypt)
+		})
+	})
+}
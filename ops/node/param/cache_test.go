@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"jolli.ai/param/fakeaws"
+)
+
+func TestCacheFallback(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/build/jolli-web/main", "String", "s3://builds/web-1.tgz")
+
+	if got, err := runCommand(t, get, "us-west-2", "/build/jolli-web/main"); err != nil || got != "s3://builds/web-1.tgz\n" {
+		t.Fatalf("get = %q, %v", got, err)
+	}
+	entries, err := os.ReadDir(os.Getenv("PARAM_CACHE_DIR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		info, _ := e.Info()
+		data, _ := os.ReadFile(filepath.Join(os.Getenv("PARAM_CACHE_DIR"), e.Name()))
+		if info.Mode().Perm() != 0600 || strings.Contains(string(data), "s3://builds") {
+			t.Errorf("%s has mode %v and holds %q", e.Name(), info.Mode().Perm(), data)
+		}
+	}
+
+	// An outage is answered from the cache, within --max-stale.
+	srv.FailWith(func(string) *fakeaws.Error {
+		return &fakeaws.Error{Status: http.StatusServiceUnavailable, Code: "ServiceUnavailable", Message: "down"}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c := openCache(time.Hour)
+	ssmSvcs, err := newSSMClients(ctx, "us-west-2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := cachedParameter(ctx, c, ssmSvcs, "/build/jolli-web/main", false)
+	if err != nil || *p.Value != "s3://builds/web-1.tgz" {
+		t.Errorf("got %v, %v; want the cached value", p, err)
+	}
+
+	c.maxStale = time.Nanosecond
+	if _, err := cachedParameter(ctx, c, ssmSvcs, "/build/jolli-web/main", false); classify(err) != classTransient {
+		t.Errorf("got %v; want the transient error, as the cached value is too old", err)
+	}
+
+	// Errors that aren't transient are never answered from the cache.
+	srv.FailWith(func(string) *fakeaws.Error {
+		return &fakeaws.Error{Status: http.StatusBadRequest, Code: "AccessDeniedException", Message: "no"}
+	})
+	c.maxStale = time.Hour
+	if _, err := cachedParameter(context.Background(), c, ssmSvcs, "/build/jolli-web/main", false); classify(err) != classAccessDenied {
+		t.Errorf("got %v; want access denied", err)
+	}
+}
+
+func TestCacheRejectsOtherKeys(t *testing.T) {
+	t.Setenv("PARAM_CACHE_DIR", t.TempDir())
+	c := openCache(time.Hour)
+	if err := c.store("get us-west-2 /a false", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// An entry moved to another key's file fails authentication.
+	if err := os.Rename(c.path("get us-west-2 /a false"), c.path("get us-west-2 /b false")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.load("get us-west-2 /b false"); err == nil {
+		t.Error("loaded an entry stored under another key")
+	}
+}
+
+func TestCacheSkipsSecretsUnlessAllowed(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/manager/prod/token/secret", "SecureString", "it's secret")
+	ssmSvcs, err := newSSMClients(context.Background(), "us-west-2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := func(string) *fakeaws.Error {
+		return &fakeaws.Error{Status: http.StatusServiceUnavailable, Code: "ServiceUnavailable", Message: "down"}
+	}
+
+	c := openCache(time.Hour)
+	if _, err := cachedParameter(context.Background(), c, ssmSvcs, "/manager/prod/token/secret", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.path(cacheKey("get", "us-west-2", "/manager/prod/token/secret", "true"))); err == nil {
+		t.Error("cached a decrypted SecureString without PARAM_CACHE_SECRETS")
+	}
+
+	t.Setenv("PARAM_CACHE_SECRETS", "true")
+	c = openCache(time.Hour)
+	if _, err := cachedEnvVarsByPath(context.Background(), c, ssmSvcs, "/manager/prod", true); err != nil {
+		t.Fatal(err)
+	}
+	srv.FailWith(down)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if vars, err := cachedEnvVarsByPath(ctx, c, ssmSvcs, "/manager/prod", true); err != nil || len(vars) != 1 || vars[0].Value != "it's secret" {
+		t.Errorf("got %v, %v; want the cached secret", vars, err)
+	}
+
+	// Once secrets are no longer allowed, the entry isn't used.
+	t.Setenv("PARAM_CACHE_SECRETS", "")
+	c = openCache(time.Hour)
+	if _, err := cachedEnvVarsByPath(ctx, c, ssmSvcs, "/manager/prod", true); classify(err) != classTransient {
+		t.Errorf("got %v; want the transient error", err)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"jolli.ai/param/serverconfig"
+)
+
+// configValue prints one value from a server's .config file, so shell scripts
+// can read it without evaluating the file.
+func configValue(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return usageError("param config <server-dir> <key>")
+	}
+
+	cfg, err := serverconfig.Load(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(cfg.Get(args[1]))
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// versionConflictError reports a destination parameter that wasn't at the
+// version a copy expected, because someone else changed it. An actual
+// version of -1 means it is unknown.
+type versionConflictError struct {
+	name     string
+	expected int64
+	actual   int64
+}
+
+func (e versionConflictError) Error() string {
+	switch {
+	case e.actual < 0:
+		return fmt.Sprintf("%s: expected version %d, but it was changed concurrently", e.name, e.expected)
+	case e.expected == 0:
+		return fmt.Sprintf("%s: expected it not to exist, found version %d", e.name, e.actual)
+	case e.actual == 0:
+		return fmt.Sprintf("%s: expected version %d, but it does not exist", e.name, e.expected)
+	}
+	return fmt.Sprintf("%s: expected version %d, found %d", e.name, e.expected, e.actual)
+}
+
+// unlockedWriteError reports a write that went through but landed on top of
+// a change made, after the destination was read, by something that doesn't
+// take param's lock, such as the console or the AWS CLI. The value written is
+// the one in SSM; the versions in between were overwritten.
+type unlockedWriteError struct {
+	name  string
+	read  int64
+	wrote int64
+}
+
+func (e unlockedWriteError) Error() string {
+	overwritten := fmt.Sprintf("version %d", e.read+1)
+	if e.wrote-e.read > 2 {
+		overwritten = fmt.Sprintf("versions %d to %d", e.read+1, e.wrote-1)
+	}
+	return fmt.Sprintf("%s: wrote version %d over %s, written by something else after version %d was read", e.name, e.wrote, overwritten, e.read)
+}
+
+// sourceParam is a parameter to copy, with what it takes to recreate it.
+type sourceParam struct {
+	name     string
+	value    string
+	typ      types.ParameterType
+	keyID    string
+	tier     types.ParameterTier
+	dataType string
+}
+
+// describedParam returns the sourceParam for a value whose parameter's KMS
+// key, tier and data type are in meta.
+func describedParam(name, value string, typ types.ParameterType, meta types.ParameterMetadata) sourceParam {
+	return sourceParam{
+		name:     name,
+		value:    value,
+		typ:      typ,
+		keyID:    aws.ToString(meta.KeyId),
+		tier:     meta.Tier,
+		dataType: aws.ToString(meta.DataType),
+	}
+}
+
+// copyParams copies a parameter, or with --path every parameter below a
+// path, keeping each one's type, KMS key, tier and data type. Each
+// destination is locked while it is read, checked against --expect-version
+// and written, so of two copies racing each other the second sees the
+// first's version and fails instead of silently overwriting it.
+func copyParams(ctx context.Context, args []string) error {
+	const copyUsage = "param copy [--path] [--if-changed] [--expect-version <n>] [--region <region>] <src> <dst>"
+
+	fs := newFlagSet("copy")
+	byPath := fs.Bool("path", false, "copy every parameter below src to the same name below dst")
+	ifChanged := fs.Bool("if-changed", false, "skip destinations that already hold the source's value and type")
+	expectVersion := fs.Int64("expect-version", -1, "fail unless dst is at this version; 0 means dst must not exist")
+	region := fs.String("region", "", "AWS region")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 || (*byPath && *expectVersion >= 0) {
+		return usageError(copyUsage)
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+	srcName, srcSelector, err := splitSelector(src)
+	if err != nil {
+		return err
+	}
+	if _, dstSelector, err := splitSelector(dst); err != nil || dstSelector != "" || (*byPath && srcSelector != "") {
+		return usageError(copyUsage)
+	}
+
+	ssmSvc, err := newSSMClient(ctx, *region)
+	if err != nil {
+		return err
+	}
+
+	if !*byPath {
+		p, err := getParameter(ctx, ssmSvc, src, true)
+		if err != nil {
+			return err
+		}
+		metas, err := describeSources(ctx, ssmSvc, nameFilter(srcName))
+		if err != nil {
+			return err
+		}
+		_, err = copyParam(ctx, ssmSvc, describedParam(src, aws.ToString(p.Value), p.Type, metas[srcName]), dst, *ifChanged, *expectVersion)
+		return err
+	}
+
+	params, err := paramsByPath(ctx, ssmSvc, src)
+	if err != nil {
+		return err
+	}
+	if len(params) == 0 {
+		return fmt.Errorf("no parameters below %s", src)
+	}
+	srcPrefix, dstPrefix := pathPrefix(src), pathPrefix(dst)
+	var errs []error
+	for _, p := range params {
+		target := dstPrefix + strings.TrimPrefix(p.name, srcPrefix)
+		if _, err := copyParam(ctx, ssmSvc, p, target, *ifChanged, -1); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// paramsByPath returns every parameter below path, decrypted, with its KMS
+// key, tier and data type.
+func paramsByPath(ctx context.Context, ssmSvc *ssm.Client, path string) ([]sourceParam, error) {
+	prefix := pathPrefix(path)
+	metas, err := describeSources(ctx, ssmSvc, types.ParameterStringFilter{
+		Key:    aws.String("Path"),
+		Option: aws.String("Recursive"),
+		Values: []string{strings.TrimSuffix(prefix, "/")},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var params []sourceParam
+	paginator := ssm.NewGetParametersByPathPaginator(ssmSvc, &ssm.GetParametersByPathInput{
+		Path:           aws.String(prefix),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting parameters by path: %w", err)
+		}
+		for _, p := range page.Parameters {
+			name := aws.ToString(p.Name)
+			params = append(params, describedParam(name, aws.ToString(p.Value), p.Type, metas[name]))
+		}
+	}
+	return params, nil
+}
+
+// describeParams returns the metadata of each parameter matching filter,
+// keyed by parameter name. GetParameter doesn't return a parameter's KMS key
+// or tier, so a copy needs both from here.
+func describeParams(ctx context.Context, ssmSvc *ssm.Client, filter types.ParameterStringFilter) (map[string]types.ParameterMetadata, error) {
+	metas := map[string]types.ParameterMetadata{}
+	paginator := ssm.NewDescribeParametersPaginator(ssmSvc, &ssm.DescribeParametersInput{
+		ParameterFilters: []types.ParameterStringFilter{filter},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("describing parameters: %w", err)
+		}
+		for _, p := range page.Parameters {
+			metas[aws.ToString(p.Name)] = p
+		}
+	}
+	return metas, nil
+}
+
+// describeSources is describeParams for the sources of a copy. A caller that
+// may not describe parameters gets no metadata and a warning instead of an
+// error, so a copy still keeps each parameter's type, and only a SecureString,
+// whose key it can't tell, fails to copy.
+func describeSources(ctx context.Context, ssmSvc *ssm.Client, filter types.ParameterStringFilter) (map[string]types.ParameterMetadata, error) {
+	metas, err := describeParams(ctx, ssmSvc, filter)
+	if classify(err) == classAccessDenied {
+		log.Printf("warning: copying without each source's KMS key, tier and data type: %v", err)
+		return nil, nil
+	}
+	return metas, err
+}
+
+// copyParam writes p's value to dst, holding dst's lock, and returns the
+// version it wrote, or 0 if ifChanged is set and dst already held the value.
+// expectVersion is the version dst must be at, 0 if it must not exist, or -1
+// to use whatever version it is at now.
+func copyParam(ctx context.Context, ssmSvc *ssm.Client, p sourceParam, dst string, ifChanged bool, expectVersion int64) (int64, error) {
+	// Without its key, a SecureString would be written with dst's key, or
+	// SSM's default, which may not be the one its readers can decrypt.
+	if p.typ == types.ParameterTypeSecureString && p.keyID == "" {
+		return 0, fmt.Errorf("%s: not copying a SecureString without knowing its KMS key; this needs ssm:DescribeParameters", p.name)
+	}
+	unlock, err := lockParam(ctx, ssmSvc, dst)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var version int64
+	current, err := getParameter(ctx, ssmSvc, dst, true)
+	var notFound *types.ParameterNotFound
+	switch {
+	case errors.As(err, &notFound):
+	case err != nil:
+		return 0, err
+	default:
+		version = current.Version
+	}
+
+	if expectVersion >= 0 && version != expectVersion {
+		return 0, versionConflictError{name: dst, expected: expectVersion, actual: version}
+	}
+	if ifChanged && current != nil && aws.ToString(current.Value) == p.value && current.Type == p.typ {
+		log.Printf("%s is unchanged at version %d", dst, version)
+		return 0, nil
+	}
+
+	// Overwrite is off when dst doesn't exist yet, so SSM itself rejects the
+	// write if something that doesn't take the lock creates it first.
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(dst),
+		Value:     aws.String(p.value),
+		Type:      p.typ,
+		Overwrite: aws.Bool(version > 0),
+	}
+	if p.typ == types.ParameterTypeSecureString && p.keyID != "" {
+		input.KeyId = aws.String(p.keyID)
+	}
+	// SSM can't move a parameter back to the Standard tier, so only an
+	// Advanced source sets the tier; a Standard one leaves dst's as it is.
+	if p.tier == types.ParameterTierAdvanced {
+		input.Tier = p.tier
+	}
+	if p.dataType != "" {
+		input.DataType = aws.String(p.dataType)
+	}
+	out, err := ssmSvc.PutParameter(ctx, input)
+	var exists *types.ParameterAlreadyExists
+	if errors.As(err, &exists) {
+		return 0, versionConflictError{name: dst, expected: 0, actual: -1}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("putting %s: %w", dst, err)
+	}
+	if out.Version != version+1 {
+		return 0, unlockedWriteError{name: dst, read: version, wrote: out.Version}
+	}
+
+	log.Printf("copied %s to %s, now at version %d", p.name, dst, out.Version)
+	return out.Version, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"jolli.ai/param/fakeaws"
+)
+
+func TestCopy(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetSecureString("/build/jolli-manager/main", "s3://builds/manager-1.tgz", "alias/builds")
+
+	if _, err := runCommand(t, copyParams, "/build/jolli-manager/main", "/build/jolli-manager/deploy/dev"); err != nil {
+		t.Fatal(err)
+	}
+	value, version, _ := srv.Parameter("/build/jolli-manager/deploy/dev")
+	if value != "s3://builds/manager-1.tgz" || version != 1 {
+		t.Errorf("copied %q as version %d", value, version)
+	}
+	if key := srv.ParameterKeyID("/build/jolli-manager/deploy/dev"); key != "alias/builds" {
+		t.Errorf("copied with key %q, want alias/builds", key)
+	}
+
+	if _, err := runCommand(t, copyParams, "--if-changed", "/build/jolli-manager/main", "/build/jolli-manager/deploy/dev"); err != nil {
+		t.Fatal(err)
+	}
+	if _, version, _ := srv.Parameter("/build/jolli-manager/deploy/dev"); version != 1 {
+		t.Errorf("--if-changed wrote version %d of an unchanged value", version)
+	}
+
+	_, err := runCommand(t, copyParams, "--expect-version", "3", "/build/jolli-manager/main", "/build/jolli-manager/deploy/dev")
+	var conflict versionConflictError
+	if !errors.As(err, &conflict) || conflict.actual != 1 {
+		t.Errorf("got %v, want a version conflict at version 1", err)
+	}
+}
+
+func TestCopyPath(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/manager/dev/db/host", "String", "db.dev")
+	srv.SetSecureString("/manager/dev/token/secret", "hunter2", "alias/dev")
+	srv.SetParameter("/manager/prod/db/host", "String", "db.prod")
+
+	if _, err := runCommand(t, copyParams, "--path", "--if-changed", "/manager/dev", "/manager/staging"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"/manager/staging/db/host":      "db.dev",
+		"/manager/staging/token/secret": "hunter2",
+	} {
+		if value, _, ok := srv.Parameter(name); !ok || value != want {
+			t.Errorf("%s = %q, want %q", name, value, want)
+		}
+	}
+	if key := srv.ParameterKeyID("/manager/staging/token/secret"); key != "alias/dev" {
+		t.Errorf("copied with key %q, want alias/dev", key)
+	}
+
+	if _, err := runCommand(t, copyParams, "--path", "/manager/dev:1", "/manager/staging"); classify(err) != classUsage {
+		t.Errorf("--path with a version selector: got %v, want a usage error", err)
+	}
+}
+
+func TestCopyTierAndDataType(t *testing.T) {
+	srv := newFakeAWS(t)
+	ssmSvc, err := newSSMClient(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ssmSvc.PutParameter(context.Background(), &ssm.PutParameterInput{
+		Name:     aws.String("/ami/node/main"),
+		Value:    aws.String("ami-0123456789abcdef0"),
+		Type:     types.ParameterTypeString,
+		Tier:     types.ParameterTierAdvanced,
+		DataType: aws.String("aws:ec2:image"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetParameter("/ami/node/prod", "String", "ami-00000000000000000")
+
+	if _, err := runCommand(t, copyParams, "/ami/node/main", "/ami/node/prod"); err != nil {
+		t.Fatal(err)
+	}
+	if tier, dataType := srv.ParameterTier("/ami/node/prod"), srv.ParameterDataType("/ami/node/prod"); tier != "Advanced" || dataType != "aws:ec2:image" {
+		t.Errorf("copied as a %s parameter of type %s, want Advanced and aws:ec2:image", tier, dataType)
+	}
+
+	// A Standard source leaves an Advanced destination in its tier.
+	srv.SetParameter("/ami/node/staging", "String", "ami-11111111111111111")
+	if _, err := runCommand(t, copyParams, "/ami/node/staging", "/ami/node/prod"); err != nil {
+		t.Fatal(err)
+	}
+	if tier := srv.ParameterTier("/ami/node/prod"); tier != "Advanced" {
+		t.Errorf("copying a Standard parameter moved dst to %s", tier)
+	}
+}
+
+func TestCopyWithoutLockOrDescribe(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/build/jolli-manager/main", "String", "s3://builds/manager-1.tgz")
+	srv.SetSecureString("/manager/dev/token/secret", "hunter2", "alias/dev")
+
+	// A role without ssm:DescribeParameters or access to /param/locks, whose
+	// first PutParameter is the lock's.
+	var mu sync.Mutex
+	puts := 0
+	srv.FailWith(func(op string) *fakeaws.Error {
+		mu.Lock()
+		defer mu.Unlock()
+		if op == "PutParameter" {
+			puts++
+		}
+		if op == "DescribeParameters" || op == "PutParameter" && puts == 1 {
+			return &fakeaws.Error{Status: http.StatusBadRequest, Code: "AccessDeniedException", Message: "no"}
+		}
+		return nil
+	})
+
+	if _, err := runCommand(t, copyParams, "--if-changed", "/build/jolli-manager/main", "/build/jolli-manager/deploy/dev"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := srv.Parameter("/build/jolli-manager/deploy/dev"); value != "s3://builds/manager-1.tgz" {
+		t.Errorf("copied %q", value)
+	}
+
+	// A SecureString isn't copied with a key that may not be its own.
+	mu.Lock()
+	puts = 0
+	mu.Unlock()
+	if _, err := runCommand(t, copyParams, "/manager/dev/token/secret", "/manager/staging/token/secret"); err == nil || !strings.Contains(err.Error(), "ssm:DescribeParameters") {
+		t.Errorf("got %v, want an error naming ssm:DescribeParameters", err)
+	}
+	if _, _, ok := srv.Parameter("/manager/staging/token/secret"); ok {
+		t.Error("copied a SecureString without its key")
+	}
+}
+
+func TestCopyInterleaved(t *testing.T) {
+	srv := newFakeAWS(t)
+	for _, url := range []string{"s3://builds/web-1.tgz", "s3://builds/web-2.tgz", "s3://builds/web-3.tgz"} {
+		srv.SetParameter("/build/jolli-web/prod", "String", url)
+	}
+	ssmSvc, err := newSSMClient(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(poll time.Duration) { lockPoll = poll }(lockPoll)
+	lockPoll = 10 * time.Millisecond
+
+	// A and B both expect version 3. A is held just before its write, its
+	// first PutParameter after reading, until B has read something too.
+	var mu sync.Mutex
+	step := 0
+	aWriting, bWaiting := make(chan struct{}), make(chan struct{})
+	srv.FailWith(func(op string) *fakeaws.Error {
+		mu.Lock()
+		s := step
+		switch {
+		case s == 0 && op == "GetParameter", s == 1 && op == "PutParameter", s == 2 && op == "GetParameter":
+			step++
+		}
+		mu.Unlock()
+		switch {
+		case s == 1 && op == "PutParameter":
+			close(aWriting)
+			<-bWaiting
+		case s == 2 && op == "GetParameter":
+			close(bWaiting)
+		}
+		return nil
+	})
+
+	copyTo := func(url string) (int64, error) {
+		return copyParam(context.Background(), ssmSvc, sourceParam{name: url, value: url, typ: "String"}, "/build/jolli-web/prod", false, 3)
+	}
+	aDone := make(chan error)
+	go func() {
+		_, err := copyTo("s3://builds/web-a.tgz")
+		aDone <- err
+	}()
+	<-aWriting
+	_, bErr := copyTo("s3://builds/web-b.tgz")
+	if err := <-aDone; err != nil {
+		t.Fatalf("A: %v", err)
+	}
+
+	var conflict versionConflictError
+	if !errors.As(bErr, &conflict) || conflict.expected != 3 || conflict.actual != 4 {
+		t.Errorf("B: got %v, want a conflict with A's version 4", bErr)
+	}
+	if value, version, _ := srv.Parameter("/build/jolli-web/prod"); value != "s3://builds/web-a.tgz" || version != 4 {
+		t.Errorf("left %q at version %d, want A's write", value, version)
+	}
+	if _, _, ok := srv.Parameter(lockPrefix + "/build/jolli-web/prod"); ok {
+		t.Error("the lock was left behind")
+	}
+}
+
+func TestCopyWaitsForLock(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/build/jolli-manager/main", "String", "s3://builds/manager-1.tgz")
+	srv.SetParameter(lockPrefix+"/build/jolli-manager/deploy/dev", "String", "ci pid 1")
+	defer func(wait, poll time.Duration) { lockWait, lockPoll = wait, poll }(lockWait, lockPoll)
+	lockWait, lockPoll = 50*time.Millisecond, 10*time.Millisecond
+
+	_, err := runCommand(t, copyParams, "/build/jolli-manager/main", "/build/jolli-manager/deploy/dev")
+	var locked lockedError
+	if !errors.As(err, &locked) || locked.owner != "ci pid 1" {
+		t.Errorf("got %v, want the lock held by ci pid 1", err)
+	}
+	if _, _, ok := srv.Parameter("/build/jolli-manager/deploy/dev"); ok {
+		t.Error("wrote while another writer held the lock")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// deployer installs builds into server directories.
+type deployer struct {
+	s3Svc *s3.Client
+	// keep is how many installs to retain after a deploy, besides current and
+	// the rollback target, unless a server's KEEP setting overrides it.
+	keep int
+	// installTimeout is how long a restarted server has to start its new
+	// app, unless a server's INSTALL_TIMEOUT setting overrides it.
+	installTimeout time.Duration
+	// healthTimeout is how long a new install has to pass its health check
+	// once its app has started, unless a server's HEALTH_TIMEOUT setting
+	// overrides it.
+	healthTimeout time.Duration
+}
+
+// currentLink is the symlink in a server directory naming the install to run.
+const currentLink = "current"
+
+// deploy downloads and verifies the build tarball at url into the server's
+// downloads directory, extracts it to installs/<name>, points current at it,
+// and restarts the app. The install is only marked good once the restarted
+// app has started and, if the server has a HEALTH_URL, answers it; otherwise
+// current is pointed back at the last good install. The last good install
+// before this one is kept as the rollback target, and old installs and
+// downloads are cleaned up once the new one is running.
+func (d *deployer) deploy(ctx context.Context, dir, url string) error {
+	install := filepath.Join("installs", strings.TrimSuffix(path.Base(url), ".tgz"))
+	downloads := filepath.Join(dir, "downloads")
+	installDir := filepath.Join(dir, install)
+
+	if err := os.MkdirAll(downloads, 0755); err != nil {
+		return err
+	}
+	tarball, err := download(ctx, d.s3Svc, url, downloads)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(installDir), 0755); err != nil {
+		return err
+	}
+	if err := extract(tarball, installDir); err != nil {
+		return err
+	}
+
+	state, err := loadState(dir)
+	if err != nil {
+		return fmt.Errorf("reading deploy state: %w", err)
+	}
+	// State written before health checks existed has no good install; the
+	// one that was running is the best rollback target there is.
+	good := state.Good
+	if good == "" {
+		good = state.Current
+	}
+
+	if _, err := swapCurrent(dir, install); err != nil {
+		return err
+	}
+	state.Current = install
+	if err := saveState(dir, state); err != nil {
+		return fmt.Errorf("saving deploy state: %w", err)
+	}
+
+	oldPID := appPID(dir)
+	if err := restartServer(dir); err != nil {
+		return err
+	}
+
+	// The URL is recorded whether or not the build turns out healthy, so a
+	// bad build isn't redeployed over and over until BUILD changes.
+	state.URL = url
+	if err := d.checkHealth(ctx, dir, oldPID); err != nil {
+		return d.revert(dir, state, good, fmt.Errorf("%s is unhealthy: %w", install, err))
+	}
+
+	if good != install {
+		state.Previous = good
+	}
+	state.Good = install
+	if err := saveState(dir, state); err != nil {
+		return fmt.Errorf("saving deploy state: %w", err)
+	}
+
+	if err := d.cleanUp(dir); err != nil {
+		log.Printf("%s: cleaning up old installs: %v", dir, err)
+	}
+	return nil
+}
+
+// revert points current back at the good install after a failed deploy and
+// restarts it, returning cause along with any error from reverting.
+func (d *deployer) revert(dir string, state deployState, good string, cause error) error {
+	if good == "" || good == state.Current {
+		if err := saveState(dir, state); err != nil {
+			return errors.Join(cause, fmt.Errorf("saving deploy state: %w", err))
+		}
+		return fmt.Errorf("%w; no good install to roll back to", cause)
+	}
+
+	if _, err := swapCurrent(dir, good); err != nil {
+		return errors.Join(cause, fmt.Errorf("rolling back to %s: %w", good, err))
+	}
+	state.Current = good
+	if err := saveState(dir, state); err != nil {
+		return errors.Join(cause, fmt.Errorf("saving deploy state: %w", err))
+	}
+	if err := restartServer(dir); err != nil {
+		return errors.Join(cause, fmt.Errorf("restarting %s: %w", good, err))
+	}
+	return fmt.Errorf("%w; rolled back to %s", cause, good)
+}
+
+// cleanUp applies the server's retention policy after a successful deploy.
+func (d *deployer) cleanUp(dir string) error {
+	keep, err := retention(dir, d.keep)
+	if err != nil {
+		return err
+	}
+	return collectGarbage(dir, keep)
+}
+
+// swapCurrent atomically points a server's current symlink at install,
+// returning the install it pointed at before, if any. The new link is created
+// under a temporary name and renamed over current, so there is never a moment
+// without one.
+func swapCurrent(dir, install string) (string, error) {
+	current := filepath.Join(dir, currentLink)
+	previous, err := os.Readlink(current)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	tmp := filepath.Join(dir, fmt.Sprintf(".%s.tmp-%d", currentLink, os.Getpid()))
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if err := os.Symlink(install, tmp); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, current); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return previous, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"jolli.ai/param/fakeaws"
+	"jolli.ai/param/serverconfig"
+)
+
+// fakeSupervisor stands in for `param run` in dir: it holds the runner lock
+// and, on each SIGHUP, records a new app PID as a restarted app would once
+// startDelay has passed to install its dependencies.
+func fakeSupervisor(t *testing.T, dir string, startDelay time.Duration) {
+	t.Helper()
+	lock, err := lockRunner(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		// A SIGHUP still on its way must not kill the test binary.
+		signal.Ignore(syscall.SIGHUP)
+		signal.Stop(hup)
+		close(done)
+		unlockRunner(lock)
+	})
+
+	go func() {
+		for pid := 1000; ; pid++ {
+			select {
+			case <-hup:
+			case <-done:
+				return
+			}
+			select {
+			case <-time.After(startDelay):
+				os.WriteFile(filepath.Join(dir, appPIDFile), []byte(strconv.Itoa(pid)+"\n"), 0644)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// newTestServer returns a server directory with the given .config, watched
+// by a fake supervisor that takes startDelay to start each app.
+func newTestServer(t *testing.T, config string, startDelay time.Duration) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, serverconfig.FileName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fakeSupervisor(t, dir, startDelay)
+	return dir
+}
+
+// putBuilds uploads a tarball to s3://builds/<name>.tgz for each name, with
+// the name in its VERSION file, and returns a deployer that reads them.
+func putBuilds(t *testing.T, srv *fakeaws.Server, names ...string) *deployer {
+	t.Helper()
+	srv.CreateBucket("builds")
+	for _, name := range names {
+		data, _ := os.ReadFile(writeTarball(t, tarEntry{name: "VERSION", body: name}))
+		srv.PutObject("builds", name+".tgz", data)
+	}
+	cfg, err := loadAWSConfig(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &deployer{s3Svc: newS3(cfg), keep: defaultKeep, installTimeout: 10 * time.Second, healthTimeout: 3 * time.Second}
+}
+
+func TestDeployAndRollback(t *testing.T) {
+	d := putBuilds(t, newFakeAWS(t), "web-1", "web-2", "web-3")
+
+	var unhealthy atomic.Bool
+	health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unhealthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer health.Close()
+
+	dir := newTestServer(t, "BUILD=/build/jolli-web/main\nHEALTH_URL="+health.URL+"\n", 0)
+
+	running := func() string {
+		data, _ := os.ReadFile(filepath.Join(dir, currentLink, "VERSION"))
+		return string(data)
+	}
+	for _, name := range []string{"web-1", "web-2"} {
+		if err := d.deploy(context.Background(), dir, "s3://builds/"+name+".tgz"); err != nil {
+			t.Fatal(err)
+		}
+		if got := running(); got != name {
+			t.Fatalf("running %q after deploying %s", got, name)
+		}
+	}
+	if state, _ := loadState(dir); state.Good != "installs/web-2" || state.Previous != "installs/web-1" {
+		t.Errorf("state after two deploys is %+v", state)
+	}
+
+	// A build that fails its health check is rolled back, and its URL is
+	// recorded so it isn't deployed again.
+	unhealthy.Store(true)
+	if err := d.deploy(context.Background(), dir, "s3://builds/web-3.tgz"); err == nil {
+		t.Error("deployed an unhealthy build")
+	}
+	unhealthy.Store(false)
+	if got := running(); got != "web-2" {
+		t.Errorf("running %q after a failed deploy, want web-2", got)
+	}
+	if state, _ := loadState(dir); state.URL != "s3://builds/web-3.tgz" || state.Current != "installs/web-2" {
+		t.Errorf("state after a failed deploy is %+v", state)
+	}
+
+	if err := rollback(context.Background(), []string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	if got := running(); got != "web-1" {
+		t.Errorf("running %q after rollback, want web-1", got)
+	}
+	if state, _ := loadState(dir); state.Good != "installs/web-1" || state.Previous != "installs/web-2" {
+		t.Errorf("state after rollback is %+v", state)
+	}
+}
+
+func TestDeployWaitsForInstall(t *testing.T) {
+	d := putBuilds(t, newFakeAWS(t), "web-1", "web-2")
+	d.healthTimeout = time.Second
+	health := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer health.Close()
+
+	// An install that takes longer than the health timeout to start its
+	// app still passes, with or without a health check.
+	for _, config := range []string{"HEALTH_URL=" + health.URL + "\n", ""} {
+		dir := newTestServer(t, config, 2*time.Second)
+		if err := d.deploy(context.Background(), dir, "s3://builds/web-1.tgz"); err != nil {
+			t.Errorf("with .config %q: %v", config, err)
+		}
+	}
+
+	// An app that never starts fails once the install timeout has passed.
+	dir := newTestServer(t, "INSTALL_TIMEOUT=1s\n", time.Hour)
+	if err := d.deploy(context.Background(), dir, "s3://builds/web-2.tgz"); err == nil || !strings.Contains(err.Error(), "did not start within 1s") {
+		t.Errorf("got %v, want the app reported as not started", err)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, serverconfig.FileName), []byte("KEEP=2\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "downloads"), 0755)
+	start := time.Now().Add(-time.Hour)
+	for i := 1; i <= 5; i++ {
+		name := "web-" + strconv.Itoa(i)
+		install := filepath.Join(dir, "installs", name)
+		os.MkdirAll(install, 0755)
+		os.Chtimes(install, start, start.Add(time.Duration(i)*time.Minute))
+		os.WriteFile(filepath.Join(dir, "downloads", name+".tgz"), nil, 0644)
+	}
+	// A deploy in progress is left alone.
+	os.MkdirAll(filepath.Join(dir, "installs", ".web-6.staging-1"), 0755)
+	swapCurrent(dir, "installs/web-5")
+	saveState(dir, deployState{Current: "installs/web-5", Good: "installs/web-5", Previous: "installs/web-1"})
+
+	if _, err := runCommand(t, gc, dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, kept := range map[string]bool{
+		"web-1": true, "web-2": false, "web-3": true, "web-4": true, "web-5": true, ".web-6.staging-1": true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, "installs", name)); (err == nil) != kept {
+			t.Errorf("install %s: kept = %v, want %v", name, err == nil, kept)
+		}
+		if name[0] == '.' {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, "downloads", name+".tgz")); (err == nil) != kept {
+			t.Errorf("download %s: kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// checksumSuffix names the sidecar object holding the SHA-256 of a build.
+const checksumSuffix = ".sha256"
+
+// checksumError reports a download whose contents don't match what S3 or the
+// published checksum says they should be.
+type checksumError struct {
+	url      string
+	kind     string
+	expected string
+	actual   string
+}
+
+func (e checksumError) Error() string {
+	return fmt.Sprintf("%s %s mismatch: expected %s, got %s", e.url, e.kind, e.expected, e.actual)
+}
+
+// parseS3URL splits an s3://bucket/key URL.
+func parseS3URL(raw string) (bucket, key string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("%q is not an s3://bucket/key URL", raw)
+	}
+	return u.Host, key, nil
+}
+
+// download streams the object at an s3:// URL into dir and returns the path
+// it was saved to. The object is verified against its published .sha256
+// sidecar if there is one, and otherwise against its ETag, before it is moved
+// into place; a corrupt or truncated download is deleted and reported as an
+// error.
+func download(ctx context.Context, s3Svc *s3.Client, rawURL, dir string) (string, error) {
+	bucket, key, err := parseS3URL(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	sidecar, err := publishedChecksum(ctx, s3Svc, bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	obj, err := s3Svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("getting %s: %w", rawURL, err)
+	}
+	defer obj.Body.Close()
+
+	etag := strings.Trim(aws.ToString(obj.ETag), `"`)
+	var etagSum *etagHash
+	if sidecar == "" && etagIsChecksum(obj.ServerSideEncryption, obj.SSECustomerAlgorithm) {
+		if etagSum, err = newETagHash(ctx, s3Svc, bucket, key, etag); err != nil {
+			return "", err
+		}
+	}
+
+	dest := filepath.Join(dir, path.Base(key))
+	tmp, err := os.CreateTemp(dir, "."+path.Base(key)+".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	sha := sha256.New()
+	writers := []io.Writer{tmp, sha}
+	if etagSum != nil {
+		writers = append(writers, etagSum)
+	}
+	n, err := io.Copy(io.MultiWriter(writers...), obj.Body)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s: %w", rawURL, err)
+	}
+
+	if size := aws.ToInt64(obj.ContentLength); obj.ContentLength != nil && n != size {
+		return "", checksumError{url: rawURL, kind: "size", expected: strconv.FormatInt(size, 10), actual: strconv.FormatInt(n, 10)}
+	}
+	switch {
+	case sidecar != "":
+		if actual := hex.EncodeToString(sha.Sum(nil)); actual != sidecar {
+			return "", checksumError{url: rawURL, kind: "sha256", expected: sidecar, actual: actual}
+		}
+	case etagSum != nil:
+		if actual := etagSum.Sum(); actual != etag {
+			return "", checksumError{url: rawURL, kind: "ETag", expected: etag, actual: actual}
+		}
+	default:
+		log.Printf("%s: no %s sidecar and its ETag is not a checksum; verified size only", rawURL, checksumSuffix)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// publishedChecksum returns the hex SHA-256 from the object's .sha256
+// sidecar, or "" if none was published. The sidecar may hold either a bare
+// digest or sha256sum output. S3 answers 403 rather than 404 for a missing
+// key when the caller can't list the bucket, so both mean no sidecar.
+func publishedChecksum(ctx context.Context, s3Svc *s3.Client, bucket, key string) (string, error) {
+	obj, err := s3Svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key + checksumSuffix),
+	})
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && (respErr.HTTPStatusCode() == http.StatusNotFound || respErr.HTTPStatusCode() == http.StatusForbidden) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting checksum for s3://%s/%s: %w", bucket, key, err)
+	}
+	defer obj.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(obj.Body, 1024))
+	if err != nil {
+		return "", fmt.Errorf("reading checksum for s3://%s/%s: %w", bucket, key, err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("s3://%s/%s%s does not hold a SHA-256 checksum", bucket, key, checksumSuffix)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// etagIsChecksum reports whether an object's ETag is derived from the MD5 of
+// its contents, which isn't the case for objects encrypted with KMS or a
+// customer-provided key.
+func etagIsChecksum(sse s3types.ServerSideEncryption, sseCustomerAlgorithm *string) bool {
+	return sseCustomerAlgorithm == nil && (sse == "" || sse == s3types.ServerSideEncryptionAes256)
+}
+
+// etagHash computes an S3 ETag: the MD5 of the object for a single-part
+// upload, or the MD5 of the concatenated part MD5s followed by -<parts> for a
+// multipart upload.
+type etagHash struct {
+	partSize int64
+	written  int64
+	part     hash.Hash
+	parts    []byte
+	count    int
+}
+
+// newETagHash prepares to verify an object against etag. For multipart
+// uploads it looks up the size of the first part, which all but the last
+// part share.
+func newETagHash(ctx context.Context, s3Svc *s3.Client, bucket, key, etag string) (*etagHash, error) {
+	h := &etagHash{part: md5.New()}
+	if !strings.Contains(etag, "-") {
+		return h, nil
+	}
+
+	head, err := s3Svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(key),
+		PartNumber: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting part size of s3://%s/%s: %w", bucket, key, err)
+	}
+	h.partSize = aws.ToInt64(head.ContentLength)
+	return h, nil
+}
+
+func (h *etagHash) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p
+		if h.partSize > 0 && int64(len(chunk)) > h.partSize-h.written {
+			chunk = chunk[:h.partSize-h.written]
+		}
+		h.part.Write(chunk)
+		h.written += int64(len(chunk))
+		p = p[len(chunk):]
+
+		if h.partSize > 0 && h.written == h.partSize {
+			h.endPart()
+		}
+	}
+	return n, nil
+}
+
+func (h *etagHash) endPart() {
+	h.parts = h.part.Sum(h.parts)
+	h.part.Reset()
+	h.written = 0
+	h.count++
+}
+
+// Sum returns the ETag of everything written so far.
+func (h *etagHash) Sum() string {
+	if h.partSize == 0 {
+		return hex.EncodeToString(h.part.Sum(nil))
+	}
+	if h.written > 0 {
+		h.endPart()
+	}
+	sum := md5.Sum(h.parts)
+	return hex.EncodeToString(sum[:]) + "-" + strconv.Itoa(h.count)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Credentials and region used against an endpoint override when the
+// environment has none. They match LocalStack's defaults.
+const (
+	testAccessKeyID     = "test"
+	testSecretAccessKey = "test"
+	testRegion          = "us-east-1"
+)
+
+// awsEndpoints overrides where SSM and S3 requests go, so param can run
+// against a local stand-in such as LocalStack. Empty fields mean AWS.
+type awsEndpoints struct {
+	ssm string
+	s3  string
+}
+
+// endpoints is set by main from --endpoint-url, PARAM_SSM_ENDPOINT and
+// PARAM_S3_ENDPOINT.
+var endpoints awsEndpoints
+
+// loadEndpoints returns the endpoint overrides. --endpoint-url applies to
+// both services and wins over PARAM_SSM_ENDPOINT and PARAM_S3_ENDPOINT.
+func loadEndpoints(endpointURL string) (awsEndpoints, error) {
+	e := awsEndpoints{
+		ssm: cmp.Or(endpointURL, os.Getenv("PARAM_SSM_ENDPOINT")),
+		s3:  cmp.Or(endpointURL, os.Getenv("PARAM_S3_ENDPOINT")),
+	}
+	for _, raw := range []string{e.ssm, e.s3} {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return e, fmt.Errorf("%q is not an http or https endpoint URL", raw)
+		}
+	}
+	return e, nil
+}
+
+func (e awsEndpoints) overridden() bool {
+	return e.ssm != "" || e.s3 != ""
+}
+
+// newSSM returns an SSM client for cfg, sent to the endpoint override if
+// there is one.
+func newSSM(cfg aws.Config) *ssm.Client {
+	return ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+		if endpoints.ssm != "" {
+			o.BaseEndpoint = aws.String(endpoints.ssm)
+		}
+	})
+}
+
+// newS3 returns an S3 client for cfg. Against an endpoint override it
+// addresses buckets path-style, as http://host/bucket/key, since an
+// emulator can't serve a DNS name per bucket.
+func newS3(cfg aws.Config) *s3.Client {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoints.s3 != "" {
+			o.BaseEndpoint = aws.String(endpoints.s3)
+			o.UsePathStyle = true
+		}
+	})
+}
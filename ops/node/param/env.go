@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var envVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// keyValues collects repeated KEY=VALUE flags.
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	return fmt.Sprint(map[string]string(kv))
+}
+
+func (kv keyValues) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || !envVarNamePattern.MatchString(key) {
+		return fmt.Errorf("expected KEY=VALUE, got %q", s)
+	}
+	kv[key] = value
+	return nil
+}
+
+// env renders every parameter below a prefix into a dotenv file, or with
+// --output shell or json into export lines or a JSON object.
+func env(ctx context.Context, args []string) error {
+	const envUsage = "param env --prefix <path> [--out <file>] [--output dotenv|shell|json] [--set KEY=VALUE]... [--region <region>] [--fallback-regions <region>,...] [--decrypt=false] [--max-stale <duration>]"
+
+	extra := keyValues{}
+	fs := newFlagSet("env")
+	prefix := fs.String("prefix", "", "parameter path to render")
+	out := fs.String("out", "-", "file to write, or - for stdout")
+	region := fs.String("region", "", "AWS region")
+	decrypt := fs.Bool("decrypt", true, "decrypt SecureString values")
+	fs.Var(extra, "set", "static KEY=VALUE to include; may be repeated")
+	output := outputFlag(fs, outputDotenv)
+	maxStale := cacheFlag(fs)
+	fallbacks := fallbackFlag(fs)
+	if err := fs.Parse(args); err != nil || *prefix == "" || fs.NArg() != 0 || *output == outputRaw {
+		return usageError(envUsage)
+	}
+
+	ssmSvcs, err := newSSMClients(ctx, *region, *fallbacks)
+	if err != nil {
+		return err
+	}
+
+	vars, err := cachedEnvVarsByPath(ctx, openCache(*maxStale), ssmSvcs, *prefix, *decrypt)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	merged := mergeEnvVars(vars, extra)
+	if *output == outputJSON {
+		values := make(map[string]string, len(merged))
+		for _, v := range merged {
+			values[v.Name] = v.Value
+		}
+		err = writeJSON(&buf, values)
+	} else {
+		err = writeEnvVars(&buf, merged, *output)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := writeFileAtomic(*out, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("writing %s: %w", *out, err)
+	}
+	return nil
+}
+
+// renderDotenv formats vars as a dotenv file, one quoted assignment per line
+// sorted by name. Static extras override parameters of the same name.
+func renderDotenv(vars []envVar, extra map[string]string) ([]byte, error) {
+	var b strings.Builder
+	for _, v := range mergeEnvVars(vars, extra) {
+		if !envVarNamePattern.MatchString(v.Name) {
+			return nil, fmt.Errorf("%q is not a valid environment variable name", v.Name)
+		}
+		quoted, err := dotenvQuote(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v.Name, err)
+		}
+		b.WriteString(v.Name)
+		b.WriteByte('=')
+		b.WriteString(quoted)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String()), nil
+}
+
+// mergeEnvVars combines parameters with static extras, which override
+// parameters of the same name, and sorts the result by name.
+func mergeEnvVars(vars []envVar, extra map[string]string) []envVar {
+	byName := make(map[string]envVar, len(vars)+len(extra))
+	for _, v := range vars {
+		byName[v.Name] = v
+	}
+	for name, value := range extra {
+		byName[name] = envVar{Name: name, Value: value}
+	}
+
+	merged := make([]envVar, 0, len(byName))
+	for _, v := range byName {
+		merged = append(merged, v)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name < merged[j].Name })
+	return merged
+}
+
+// dotenvQuote quotes a value so that Node's dotenv, which the apps load
+// their .env with, reads it back unchanged. dotenv has no escapes apart from
+// \n and \r inside double quotes, so the quotes are picked by what the value
+// holds: single quotes unless it has a single quote or line break, then
+// backticks unless it has a backtick or carriage return, then double quotes,
+// with line breaks written as \n and \r, unless it has a double quote or a
+// backslash dotenv would take for one of those. No other value can be
+// written. The result is for dotenv only; a shell would run what is in
+// backticks.
+func dotenvQuote(value string) (string, error) {
+	switch {
+	case !strings.ContainsAny(value, "'\n\r"):
+		return "'" + value + "'", nil
+	case !strings.ContainsAny(value, "`\r"):
+		return "`" + value + "`", nil
+	case !strings.Contains(value, `"`) && !strings.Contains(value, `\n`) && !strings.Contains(value, `\r`):
+		return `"` + strings.NewReplacer("\n", `\n`, "\r", `\r`).Replace(value) + `"`, nil
+	}
+	return "", errors.New(`no dotenv quoting keeps this value: it holds a single quote or line break, a backtick or carriage return, and a double quote or a literal \n or \r`)
+}
+
+// dotenvLine matches one assignment the same way as the parser in dotenv 17.
+var dotenvLine = regexp.MustCompile(`(?m)(?:^|^)\s*(?:export\s+)?([\w.-]+)(?:\s*=\s*?|:\s+?)(\s*'(?:\\'|[^'])*'|\s*"(?:\\"|[^"])*"|\s*` + "`(?:\\\\`|[^`])*`" + `|[^#\r\n]+)?\s*(?:#.*)?(?:$|$)`)
+
+// parseDotenv reads a dotenv file the way Node's dotenv 17 does, so an app
+// started with a START command sees the same environment from its .env as
+// one that loads the file itself. Quotes are removed, and inside double
+// quotes \n and \r become line breaks; nothing else is unescaped.
+func parseDotenv(data []byte) map[string]string {
+	src := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(string(data))
+	vars := map[string]string{}
+	for _, m := range dotenvLine.FindAllStringSubmatch(src, -1) {
+		value := strings.TrimSpace(m[2])
+		doubleQuoted := strings.HasPrefix(value, `"`)
+		if len(value) >= 2 && strings.IndexByte("'\"`", value[0]) >= 0 && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if doubleQuoted {
+			value = strings.NewReplacer(`\n`, "\n", `\r`, "\r").Replace(value)
+		}
+		vars[m[1]] = value
+	}
+	return vars
+}
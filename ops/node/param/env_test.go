@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	// Each case is what dotenv 17 reads from the line.
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`A=plain`, "plain"},
+		{`A=plain # comment`, "plain"},
+		{`export A = spaced `, "spaced"},
+		{`A='single $HOME \n'`, `single $HOME \n`},
+		{`A="double\nline"`, "double\nline"},
+		{`A="back\\slash \$ \" kept"`, `back\\slash \$ \" kept`},
+		{"A=`it's \"both\"`", `it's "both"`},
+		{"A=`multi\nline`", "multi\nline"},
+		{`A=`, ""},
+	}
+	for _, tt := range tests {
+		if got := parseDotenv([]byte(tt.line + "\n")); got["A"] != tt.want {
+			t.Errorf("%s read as %q, want %q", tt.line, got["A"], tt.want)
+		}
+	}
+}
+
+func TestDotenvQuote(t *testing.T) {
+	values := []string{
+		"",
+		"plain",
+		`p@ss$w0rd\with"quotes`,
+		"it's",
+		"it's `quoted`",
+		"multi\nline 'key'",
+		"-----BEGIN KEY-----\r\nabc\r\n-----END KEY-----",
+		" padded ",
+	}
+	for _, value := range values {
+		quoted, err := dotenvQuote(value)
+		if err != nil {
+			t.Errorf("quoting %q: %v", value, err)
+			continue
+		}
+		if got := parseDotenv([]byte("A=" + quoted + "\n"))["A"]; got != value {
+			t.Errorf("%q quoted as %s reads back as %q", value, quoted, got)
+		}
+	}
+
+	for _, value := range []string{
+		"it's `a` \"b\"",
+		"line\nbreak `and` \"quote\"",
+		"cr\r`tick` and \\n",
+	} {
+		if quoted, err := dotenvQuote(value); err == nil {
+			t.Errorf("quoting %q gave %s, want an error", value, quoted)
+		}
+	}
+}
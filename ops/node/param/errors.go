@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// errorClass is the kind of failure a command ended with. Each class is also
+// param's exit status, so scripts can tell a missing parameter from an outage
+// without parsing the message, which goes to stderr.
+type errorClass int
+
+const (
+	classOther        errorClass = 1
+	classUsage        errorClass = 2
+	classNotFound     errorClass = 3
+	classAccessDenied errorClass = 4
+	classTransient    errorClass = 5
+	classDecrypt      errorClass = 6
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case classUsage:
+		return "usage"
+	case classNotFound:
+		return "not found"
+	case classAccessDenied:
+		return "access denied"
+	case classTransient:
+		return "transient"
+	case classDecrypt:
+		return "decrypt"
+	}
+	return "error"
+}
+
+// API error codes of each class, from SSM, S3, STS and KMS.
+var (
+	notFoundCodes = map[string]bool{
+		"ParameterNotFound":        true,
+		"ParameterVersionNotFound": true,
+		"NoSuchKey":                true,
+		"NoSuchBucket":             true,
+		"NotFound":                 true,
+	}
+	accessDeniedCodes = map[string]bool{
+		"AccessDenied":                true,
+		"AccessDeniedException":       true,
+		"UnauthorizedOperation":       true,
+		"UnrecognizedClientException": true,
+		"InvalidClientTokenId":        true,
+		"ExpiredToken":                true,
+		"ExpiredTokenException":       true,
+		"InvalidAccessKeyId":          true,
+		"SignatureDoesNotMatch":       true,
+		"InvalidSignatureException":   true,
+	}
+	decryptCodes = map[string]bool{
+		"InvalidKeyId":               true,
+		"InvalidCiphertextException": true,
+		"IncorrectKeyException":      true,
+		"DisabledException":          true,
+		"KMSInvalidStateException":   true,
+		"KMSNotFoundException":       true,
+		"KMSAccessDeniedException":   true,
+		"KMSDisabledException":       true,
+	}
+)
+
+// classify returns the class of err. API errors are classified by their
+// code, falling back to the HTTP status for responses without one, such as
+// S3's HeadObject. Anything the SDK's retryer would have retried is
+// transient, as is running out of time.
+func classify(err error) errorClass {
+	var usage usageError
+	var encrypted encryptedError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usage):
+		return classUsage
+	case errors.As(err, &encrypted):
+		return classDecrypt
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+		switch {
+		case decryptCodes[code]:
+			return classDecrypt
+		// SSM reports a KMS key it may not use as plain access denied.
+		case accessDeniedCodes[code] && strings.Contains(apiErr.ErrorMessage(), "KMS"):
+			return classDecrypt
+		case accessDeniedCodes[code]:
+			return classAccessDenied
+		case notFoundCodes[code]:
+			return classNotFound
+		}
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusNotFound:
+			return classNotFound
+		case http.StatusForbidden:
+			return classAccessDenied
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) ||
+		retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary {
+		return classTransient
+	}
+	return classOther
+}
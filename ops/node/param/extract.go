@@ -0,0 +1,235 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// unsafeEntryError reports a tarball entry that would be written, or would
+// link, outside the directory it is extracted into.
+type unsafeEntryError struct {
+	name   string
+	reason string
+}
+
+func (e unsafeEntryError) Error() string {
+	return fmt.Sprintf("refusing to extract %q: %s", e.name, e.reason)
+}
+
+// extract unpacks a gzipped tarball to dest. The tarball is extracted into a
+// staging directory next to dest and only renamed into place, replacing any
+// existing dest, once every entry has been written, so dest is never left
+// half-extracted.
+func extract(tarball, dest string) error {
+	parent, base := filepath.Split(dest)
+	staging, err := os.MkdirTemp(parent, "."+base+".staging-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	if err := extractInto(tarball, staging); err != nil {
+		return err
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		return err
+	}
+
+	old := filepath.Join(parent, "."+base+".old")
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(dest, old); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(staging, dest); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// extractInto writes every entry of a gzipped tarball below dir. Entries are
+// written through an os.Root so nothing, including a path through a symlink
+// created by an earlier entry, can land outside dir; absolute paths, ".."
+// components and links pointing outside dir are rejected outright. Once every
+// entry is written, each symlink is followed hop by hop to check that chains
+// of links don't escape dir either.
+func extractInto(tarball, dir string) error {
+	if err := extractEntries(tarball, dir); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if linkEscapes(dir, filepath.ToSlash(rel)) {
+			return unsafeEntryError{name: rel, reason: "symlink resolves outside install directory"}
+		}
+		return nil
+	})
+}
+
+func extractEntries(tarball, dir string) error {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", tarball, err)
+	}
+	defer gz.Close()
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", tarball, err)
+		}
+
+		name, err := entryPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+		if err := root.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+
+		mode := hdr.FileInfo().Mode().Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(name, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(root, name, mode, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := checkLinkTarget(name, hdr.Linkname); err != nil {
+				return err
+			}
+			if err := root.Symlink(hdr.Linkname, name); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, err := entryPath(hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := root.Link(target, name); err != nil {
+				return err
+			}
+		default:
+			return unsafeEntryError{name: hdr.Name, reason: fmt.Sprintf("unsupported entry type %q", hdr.Typeflag)}
+		}
+	}
+}
+
+func extractFile(root *os.Root, name string, mode os.FileMode, r io.Reader) error {
+	f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// entryPath validates a tarball entry name and returns it as a clean
+// relative path.
+func entryPath(name string) (string, error) {
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) {
+		return "", unsafeEntryError{name: name, reason: "absolute path"}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", unsafeEntryError{name: name, reason: `path contains ".."`}
+		}
+	}
+	return filepath.Clean(filepath.FromSlash(name)), nil
+}
+
+// checkLinkTarget rejects a symlink at name whose target is absolute or
+// resolves outside the extraction root.
+func checkLinkTarget(name, target string) error {
+	if strings.HasPrefix(target, "/") || filepath.IsAbs(target) {
+		return unsafeEntryError{name: name, reason: "symlink to absolute path " + target}
+	}
+	resolved := path.Join(path.Dir(filepath.ToSlash(name)), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return unsafeEntryError{name: name, reason: "symlink escapes install directory to " + target}
+	}
+	return nil
+}
+
+// linkEscapes reports whether resolving rel inside dir, following symlinks
+// from the directory each one actually lives in, ever steps above dir.
+// Components that don't exist are resolved lexically.
+func linkEscapes(dir, rel string) bool {
+	var resolved []string
+	todo := strings.Split(rel, "/")
+	for hops := 0; len(todo) > 0; {
+		part := todo[0]
+		todo = todo[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return true
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		next := append(slices.Clone(resolved), part)
+		full := filepath.Join(dir, filepath.Join(next...))
+		info, err := os.Lstat(full)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		// Treat link cycles as unsafe rather than looping forever.
+		if hops++; hops > 255 {
+			return true
+		}
+		target, err := os.Readlink(full)
+		if err != nil || strings.HasPrefix(target, "/") {
+			return true
+		}
+		todo = append(strings.Split(target, "/"), todo...)
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is one entry of a test tarball. A linkname makes it a symlink,
+// or a hardlink if hard is set; a name ending in / makes it a directory.
+type tarEntry struct {
+	name     string
+	body     string
+	linkname string
+	hard     bool
+}
+
+// writeTarball writes a gzipped tarball of entries to a temporary file.
+func writeTarball(t *testing.T, entries ...tarEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.hard:
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, e.linkname, 0
+		case e.linkname != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.linkname, 0
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "build.tgz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		// want is the content of each file that must exist after a
+		// successful extract; a nil want means extract must fail.
+		want map[string]string
+	}{
+		{
+			name: "dot slash root",
+			entries: []tarEntry{
+				{name: "./"},
+				{name: "./app/"},
+				{name: "./app/index.js", body: "main"},
+				{name: "./lib", linkname: "app"},
+			},
+			want: map[string]string{"app/index.js": "main", "lib/index.js": "main"},
+		},
+		{
+			name: "hardlink inside",
+			entries: []tarEntry{
+				{name: "a", body: "data"},
+				{name: "b", linkname: "a", hard: true},
+			},
+			want: map[string]string{"b": "data"},
+		},
+		{
+			name:    "parent path",
+			entries: []tarEntry{{name: "../x", body: "evil"}},
+		},
+		{
+			name:    "nested parent path",
+			entries: []tarEntry{{name: "app/../../x", body: "evil"}},
+		},
+		{
+			name:    "absolute path",
+			entries: []tarEntry{{name: "/etc/x", body: "evil"}},
+		},
+		{
+			name:    "absolute symlink",
+			entries: []tarEntry{{name: "etc", linkname: "/etc"}},
+		},
+		{
+			name:    "symlink to parent",
+			entries: []tarEntry{{name: "app/up", linkname: "../../"}},
+		},
+		{
+			name: "file through symlink",
+			entries: []tarEntry{
+				{name: "x/"},
+				{name: "x/l", linkname: ".."},
+				{name: "y", linkname: "x/l/.."},
+				{name: "y/x", body: "evil"},
+			},
+		},
+		{
+			name: "chained relative symlinks",
+			entries: []tarEntry{
+				{name: "a", linkname: "."},
+				{name: "b", linkname: "a/.."},
+			},
+		},
+		{
+			name:    "hardlink to parent",
+			entries: []tarEntry{{name: "passwd", linkname: "../x", hard: true}},
+		},
+		{
+			name:    "hardlink to absolute path",
+			entries: []tarEntry{{name: "passwd", linkname: "/etc/passwd", hard: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The tarball is extracted into a staging directory next to
+			// dest, so an entry one level up would land on this file.
+			installs := t.TempDir()
+			outside := filepath.Join(installs, "x")
+			os.WriteFile(outside, []byte("outside"), 0644)
+			dest := filepath.Join(installs, "build")
+
+			err := extract(writeTarball(t, tt.entries...), dest)
+			if data, _ := os.ReadFile(outside); string(data) != "outside" {
+				t.Errorf("file outside the install now holds %q", data)
+			}
+			if tt.want == nil {
+				if err == nil {
+					t.Fatal("extracted an unsafe tarball")
+				}
+				if _, err := os.Stat(dest); err == nil {
+					t.Error("left a partial install behind")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, body := range tt.want {
+				if data, err := os.ReadFile(filepath.Join(dest, name)); err != nil || string(data) != body {
+					t.Errorf("%s holds %q, %v; want %q", name, data, err, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Package fakeaws is an in-process stand-in for the parts of AWS Systems
+// Manager Parameter Store and S3 that param uses, so param can be tested
+// without AWS credentials or network access.
+//
+// A Server answers the SSM JSON protocol and the S3 REST API with
+// path-style addressing on one httptest listener:
+//
+//	srv := fakeaws.New()
+//	defer srv.Close()
+//	srv.SetParameter("/build/web/main", "String", "s3://builds/web-1.0.0.tgz")
+//	srv.PutObject("builds", "web-1.0.0.tgz", tarball)
+//
+// Point the AWS SDK at srv.URL, for example with AWS_ENDPOINT_URL, and give
+// it any static credentials; requests are not authenticated.
+//
+// SSM supports GetParameter, GetParameters, GetParametersByPath,
+// PutParameter, DeleteParameter, GetParameterHistory, LabelParameterVersion
+// and DescribeParameters, including name:version and name:label selectors
+// and paging. S3 supports GetObject, HeadObject, PutObject and ListObjectsV2.
+package fakeaws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake SSM and S3 endpoint. Its methods are safe for concurrent
+// use, and it may be seeded and inspected while requests are in flight.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	params  map[string]*parameter
+	buckets map[string]map[string]*object
+	hook    func(operation string) *Error
+	now     func() time.Time
+}
+
+// Error is an AWS error response: an HTTP status and an error code such as
+// ThrottlingException.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// New starts a Server. Close it when done.
+func New() *Server {
+	s := &Server{
+		params:  map[string]*parameter{},
+		buckets: map[string]map[string]*object{},
+		now:     time.Now,
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// FailWith makes the server answer requests with the error hook returns for
+// their operation, such as "GetParameter" or "GetObject", instead of serving
+// them. A nil error serves the request as usual, and a nil hook stops
+// failing requests. Use it to simulate throttling, outages and missing
+// permissions.
+func (s *Server) FailWith(hook func(operation string) *Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = hook
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if target := r.Header.Get("X-Amz-Target"); strings.HasPrefix(target, ssmTargetPrefix) {
+		s.serveSSM(w, r, strings.TrimPrefix(target, ssmTargetPrefix))
+		return
+	}
+	s.serveS3(w, r)
+}
+
+// failure returns the error the FailWith hook has for operation, if any.
+func (s *Server) failure(operation string) *Error {
+	s.mu.Lock()
+	hook := s.hook
+	s.mu.Unlock()
+	if hook == nil {
+		return nil
+	}
+	return hook(operation)
+}
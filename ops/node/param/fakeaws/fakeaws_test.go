@@ -0,0 +1,333 @@
+package fakeaws_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+	"jolli.ai/param/fakeaws"
+)
+
+func newClients(t *testing.T) (*fakeaws.Server, *ssm.Client, *s3.Client) {
+	t.Helper()
+	srv := fakeaws.New()
+	t.Cleanup(srv.Close)
+	cfg := aws.Config{
+		Region:       "us-west-2",
+		Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		BaseEndpoint: aws.String(srv.URL),
+	}
+	return srv, ssm.NewFromConfig(cfg), s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = true })
+}
+
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func TestGetParameter(t *testing.T) {
+	srv, ssmSvc, _ := newClients(t)
+	ctx := context.Background()
+	srv.SetParameter("/build/web/main", "String", "s3://builds/web-1.tgz")
+	srv.SetParameter("/build/web/main", "String", "s3://builds/web-2.tgz")
+	srv.SetSecureString("/manager/prod/token", "hunter2", "alias/prod")
+
+	out, err := ssmSvc.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String("/build/web/main")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := out.Parameter; aws.ToString(p.Value) != "s3://builds/web-2.tgz" || p.Version != 2 || p.Type != types.ParameterTypeString {
+		t.Errorf("got %s version %d of type %s", aws.ToString(p.Value), p.Version, p.Type)
+	}
+	if arn := aws.ToString(out.Parameter.ARN); arn != "arn:aws:ssm:us-west-2:123456789012:parameter/build/web/main" {
+		t.Errorf("got ARN %s", arn)
+	}
+	if out.Parameter.LastModifiedDate == nil || out.Parameter.LastModifiedDate.IsZero() {
+		t.Error("got no LastModifiedDate")
+	}
+
+	out, err = ssmSvc.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String("/build/web/main:1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(out.Parameter.Value) != "s3://builds/web-1.tgz" || aws.ToString(out.Parameter.Selector) != ":1" {
+		t.Errorf("got %s with selector %s", aws.ToString(out.Parameter.Value), aws.ToString(out.Parameter.Selector))
+	}
+
+	out, err = ssmSvc.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String("/manager/prod/token")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(out.Parameter.Value) == "hunter2" {
+		t.Error("got the plaintext of a SecureString read without decryption")
+	}
+	out, err = ssmSvc.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String("/manager/prod/token"), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(out.Parameter.Value) != "hunter2" {
+		t.Errorf("got %q", aws.ToString(out.Parameter.Value))
+	}
+
+	for name, code := range map[string]string{
+		"/missing":           "ParameterNotFound",
+		"/build/web/main:3":  "ParameterVersionNotFound",
+		"/build/web/main:qa": "ParameterVersionNotFound",
+	} {
+		_, err := ssmSvc.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name)})
+		if got := errorCode(err); got != code {
+			t.Errorf("%s: got error %v, want %s", name, err, code)
+		}
+	}
+}
+
+func TestGetParameters(t *testing.T) {
+	srv, ssmSvc, _ := newClients(t)
+	srv.SetParameter("/a", "String", "1")
+	srv.SetParameter("/b", "StringList", "2,3")
+
+	out, err := ssmSvc.GetParameters(context.Background(), &ssm.GetParametersInput{Names: []string{"/a", "/b", "/c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Parameters) != 2 || aws.ToString(out.Parameters[1].Value) != "2,3" {
+		t.Errorf("got %d parameters", len(out.Parameters))
+	}
+	if !slices.Equal(out.InvalidParameters, []string{"/c"}) {
+		t.Errorf("got invalid parameters %v", out.InvalidParameters)
+	}
+}
+
+func TestGetParametersByPath(t *testing.T) {
+	srv, ssmSvc, _ := newClients(t)
+	for i := range 25 {
+		srv.SetParameter(fmt.Sprintf("/app/prod/key%02d", i), "String", "v")
+	}
+	srv.SetParameter("/app/prod/nested/deep", "String", "v")
+	srv.SetParameter("/app/production", "String", "v")
+
+	names := func(recursive bool) []string {
+		var names []string
+		paginator := ssm.NewGetParametersByPathPaginator(ssmSvc, &ssm.GetParametersByPathInput{
+			Path:      aws.String("/app/prod"),
+			Recursive: aws.Bool(recursive),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Parameters) > 10 {
+				t.Fatalf("got a page of %d parameters", len(page.Parameters))
+			}
+			for _, p := range page.Parameters {
+				names = append(names, aws.ToString(p.Name))
+			}
+		}
+		return names
+	}
+
+	if got := names(false); len(got) != 25 || slices.Contains(got, "/app/prod/nested/deep") {
+		t.Errorf("one level: got %d parameters: %v", len(got), got)
+	}
+	if got := names(true); len(got) != 26 || !slices.Contains(got, "/app/prod/nested/deep") || slices.Contains(got, "/app/production") {
+		t.Errorf("recursive: got %d parameters: %v", len(got), got)
+	}
+}
+
+func TestPutParameter(t *testing.T) {
+	srv, ssmSvc, _ := newClients(t)
+	ctx := context.Background()
+
+	out, err := ssmSvc.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:  aws.String("/secret"),
+		Value: aws.String("one"),
+		Type:  types.ParameterTypeSecureString,
+		KeyId: aws.String("alias/custom"),
+	})
+	if err != nil || out.Version != 1 {
+		t.Fatalf("got version %v, %v", out, err)
+	}
+	if key := srv.ParameterKeyID("/secret"); key != "alias/custom" {
+		t.Errorf("got key %s", key)
+	}
+
+	_, err = ssmSvc.PutParameter(ctx, &ssm.PutParameterInput{Name: aws.String("/secret"), Value: aws.String("two")})
+	if code := errorCode(err); code != "ParameterAlreadyExists" {
+		t.Errorf("got error %v, want ParameterAlreadyExists", err)
+	}
+
+	out, err = ssmSvc.PutParameter(ctx, &ssm.PutParameterInput{Name: aws.String("/secret"), Value: aws.String("two"), Overwrite: aws.Bool(true)})
+	if err != nil || out.Version != 2 {
+		t.Fatalf("got version %v, %v", out, err)
+	}
+	if value, version, _ := srv.Parameter("/secret"); value != "two" || version != 2 || srv.ParameterKeyID("/secret") != "alias/custom" {
+		t.Errorf("got %s version %d with key %s", value, version, srv.ParameterKeyID("/secret"))
+	}
+
+	if _, err := ssmSvc.DeleteParameter(ctx, &ssm.DeleteParameterInput{Name: aws.String("/secret")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := srv.Parameter("/secret"); ok {
+		t.Error("/secret survived its deletion")
+	}
+	_, err = ssmSvc.DeleteParameter(ctx, &ssm.DeleteParameterInput{Name: aws.String("/secret")})
+	if code := errorCode(err); code != "ParameterNotFound" {
+		t.Errorf("got error %v, want ParameterNotFound", err)
+	}
+}
+
+func TestHistoryAndLabels(t *testing.T) {
+	srv, ssmSvc, _ := newClients(t)
+	ctx := context.Background()
+	for i := range 3 {
+		srv.SetParameter("/p", "String", fmt.Sprint(i))
+	}
+
+	label := func(version int64, labels ...string) *ssm.LabelParameterVersionOutput {
+		t.Helper()
+		out, err := ssmSvc.LabelParameterVersion(ctx, &ssm.LabelParameterVersionInput{
+			Name:             aws.String("/p"),
+			ParameterVersion: aws.Int64(version),
+			Labels:           labels,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	label(1, "prod")
+	if out := label(2, "prod", "aws-reserved", "1st"); !slices.Equal(out.InvalidLabels, []string{"aws-reserved", "1st"}) {
+		t.Errorf("got invalid labels %v", out.InvalidLabels)
+	}
+
+	out, err := ssmSvc.GetParameterHistory(ctx, &ssm.GetParameterHistoryInput{Name: aws.String("/p"), MaxResults: aws.Int32(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Parameters) != 2 || out.NextToken == nil {
+		t.Fatalf("got %d versions and next token %v", len(out.Parameters), out.NextToken)
+	}
+	if len(out.Parameters[0].Labels) != 0 || !slices.Equal(out.Parameters[1].Labels, []string{"prod"}) {
+		t.Errorf("a label should move to the version it was last put on; got %v and %v", out.Parameters[0].Labels, out.Parameters[1].Labels)
+	}
+
+	got, err := ssmSvc.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String("/p:prod")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Parameter.Version != 2 {
+		t.Errorf("/p:prod is version %d, want 2", got.Parameter.Version)
+	}
+}
+
+func TestDescribeParameters(t *testing.T) {
+	srv, ssmSvc, _ := newClients(t)
+	srv.SetSecureString("/app/a", "x", "alias/a")
+	srv.SetParameter("/app/b", "String", "y")
+	srv.SetParameter("/other", "String", "z")
+
+	out, err := ssmSvc.DescribeParameters(context.Background(), &ssm.DescribeParametersInput{
+		ParameterFilters: []types.ParameterStringFilter{{
+			Key:    aws.String("Path"),
+			Option: aws.String("Recursive"),
+			Values: []string{"/app"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Parameters) != 2 || aws.ToString(out.Parameters[0].KeyId) != "alias/a" || out.Parameters[1].KeyId != nil {
+		t.Errorf("got %+v", out.Parameters)
+	}
+}
+
+func TestObjects(t *testing.T) {
+	srv, _, s3Svc := newClients(t)
+	ctx := context.Background()
+	srv.CreateBucket("builds")
+
+	if _, err := s3Svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("builds"),
+		Key:    aws.String("web/1.0.0/web.tgz"),
+		Body:   strings.NewReader("tarball"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := srv.Object("builds", "web/1.0.0/web.tgz"); string(data) != "tarball" || !ok {
+		t.Errorf("stored %q", data)
+	}
+
+	obj, err := s3Svc.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("builds"), Key: aws.String("web/1.0.0/web.tgz")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if string(data) != "tarball" || aws.ToInt64(obj.ContentLength) != 7 || aws.ToString(obj.ETag) == "" {
+		t.Errorf("got %q of length %d with ETag %s", data, aws.ToInt64(obj.ContentLength), aws.ToString(obj.ETag))
+	}
+
+	_, err = s3Svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("builds"), Key: aws.String("missing")})
+	if code := errorCode(err); code != "NotFound" {
+		t.Errorf("HeadObject: got %v, want NotFound", err)
+	}
+	_, err = s3Svc.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("builds"), Key: aws.String("missing")})
+	if code := errorCode(err); code != "NoSuchKey" {
+		t.Errorf("GetObject: got %v, want NoSuchKey", err)
+	}
+
+	for i := range 5 {
+		srv.PutObject("builds", fmt.Sprintf("api/%d.tgz", i), []byte("x"))
+	}
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(s3Svc, &s3.ListObjectsV2Input{
+		Bucket:  aws.String("builds"),
+		Prefix:  aws.String("api/"),
+		MaxKeys: aws.Int32(2),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range page.Contents {
+			keys = append(keys, aws.ToString(o.Key))
+		}
+	}
+	if len(keys) != 5 || keys[0] != "api/0.tgz" {
+		t.Errorf("listed %v", keys)
+	}
+}
+
+func TestFailWith(t *testing.T) {
+	srv, ssmSvc, _ := newClients(t)
+	srv.SetParameter("/p", "String", "v")
+	calls := 0
+	srv.FailWith(func(operation string) *fakeaws.Error {
+		if calls++; calls == 1 {
+			return &fakeaws.Error{Status: http.StatusBadRequest, Code: "ThrottlingException", Message: "rate exceeded"}
+		}
+		return nil
+	})
+
+	// The SDK retries the throttled call.
+	out, err := ssmSvc.GetParameter(context.Background(), &ssm.GetParameterInput{Name: aws.String("/p")})
+	if err != nil || aws.ToString(out.Parameter.Value) != "v" || calls != 2 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+}
@@ -0,0 +1,290 @@
+package fakeaws
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type object struct {
+	data        []byte
+	etag        string
+	contentType string
+	modified    time.Time
+}
+
+// CreateBucket creates an empty bucket if it doesn't exist yet.
+func (s *Server) CreateBucket(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = map[string]*object{}
+	}
+}
+
+// PutObject stores an object, creating its bucket if need be.
+func (s *Server) PutObject(bucket, key string, data []byte) {
+	s.CreateBucket(bucket)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[bucket][key] = s.newObject(data, "")
+}
+
+// Object returns an object's contents and whether it exists.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	return bytes.Clone(o.data), true
+}
+
+func (s *Server) newObject(data []byte, contentType string) *object {
+	sum := md5.Sum(data)
+	return &object{
+		data:        bytes.Clone(data),
+		etag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		contentType: cmp.Or(contentType, "binary/octet-stream"),
+		modified:    s.now().UTC().Truncate(time.Second),
+	}
+}
+
+// serveS3 answers one S3 REST request addressed path-style, as
+// /bucket/key.
+func (s *Server) serveS3(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	var operation string
+	switch {
+	case bucket == "":
+		operation = "ListBuckets"
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		operation = "ListObjectsV2"
+	case key != "" && r.Method == http.MethodGet:
+		operation = "GetObject"
+	case key != "" && r.Method == http.MethodHead:
+		operation = "HeadObject"
+	case key != "" && r.Method == http.MethodPut:
+		operation = "PutObject"
+	}
+	if operation == "" || operation == "ListBuckets" {
+		writeS3Error(w, r, &Error{Status: http.StatusNotImplemented, Code: "NotImplemented", Message: r.Method + " " + r.URL.String() + " is not supported by fakeaws"})
+		return
+	}
+	if e := s.failure(operation); e != nil {
+		writeS3Error(w, r, e)
+		return
+	}
+
+	var e *Error
+	switch operation {
+	case "ListObjectsV2":
+		e = s.listObjects(w, r, bucket)
+	case "GetObject", "HeadObject":
+		e = s.getObject(w, r, bucket, key)
+	case "PutObject":
+		e = s.putObject(w, r, bucket, key)
+	}
+	if e != nil {
+		writeS3Error(w, r, e)
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, e *Error) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.Status)
+	// Like S3, HEAD responses carry the status alone.
+	if r.Method == http.MethodHead {
+		return
+	}
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: e.Code, Message: e.Message})
+}
+
+// lookup finds an object. s.mu must be held.
+func (s *Server) lookup(bucket, key string) (*object, *Error) {
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return nil, &Error{Status: http.StatusNotFound, Code: "NoSuchBucket", Message: "the bucket " + bucket + " does not exist"}
+	}
+	o, ok := objects[key]
+	if !ok {
+		return nil, &Error{Status: http.StatusNotFound, Code: "NoSuchKey", Message: "the key " + key + " does not exist"}
+	}
+	return o, nil
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) *Error {
+	s.mu.Lock()
+	o, e := s.lookup(bucket, key)
+	s.mu.Unlock()
+	if e != nil {
+		return e
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+	w.Header().Set("Content-Type", o.contentType)
+	w.Header().Set("ETag", o.etag)
+	w.Header().Set("Last-Modified", o.modified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(o.data)
+	}
+	return nil
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) *Error {
+	body := io.Reader(r.Body)
+	if strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") || strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body = &chunkedReader{r: bufio.NewReader(r.Body)}
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return &Error{Status: http.StatusBadRequest, Code: "IncompleteBody", Message: err.Error()}
+	}
+	if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" && decoded != strconv.Itoa(len(data)) {
+		return &Error{Status: http.StatusBadRequest, Code: "IncompleteBody", Message: "body is " + strconv.Itoa(len(data)) + " bytes, expected " + decoded}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return &Error{Status: http.StatusNotFound, Code: "NoSuchBucket", Message: "the bucket " + bucket + " does not exist"}
+	}
+	o := s.newObject(data, r.Header.Get("Content-Type"))
+	objects[key] = o
+	w.Header().Set("ETag", o.etag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) *Error {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return &Error{Status: http.StatusBadRequest, Code: "InvalidArgument", Message: "invalid max-keys " + v}
+		}
+		maxKeys = min(n, 1000)
+	}
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		after = token
+	}
+
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		s.mu.Unlock()
+		return &Error{Status: http.StatusNotFound, Code: "NoSuchBucket", Message: "the bucket " + bucket + " does not exist"}
+	}
+	var keys []string
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		Contents              []content
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+	}{Name: bucket, Prefix: prefix, MaxKeys: maxKeys, ContinuationToken: query.Get("continuation-token")}
+	for _, key := range keys {
+		if len(result.Contents) == maxKeys {
+			result.IsTruncated = maxKeys > 0
+			if result.IsTruncated {
+				result.NextContinuationToken = result.Contents[maxKeys-1].Key
+			}
+			break
+		}
+		o := objects[key]
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: o.modified.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         o.etag,
+			Size:         len(o.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	s.mu.Unlock()
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+	return nil
+}
+
+// chunkedReader decodes an aws-chunked request body, in which the SDK
+// sends a payload as hex-length-prefixed chunks followed by trailing
+// checksum headers.
+type chunkedReader struct {
+	r       *bufio.Reader
+	pending int
+	done    bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.pending == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, fmt.Errorf("reading chunk header: %w", err)
+		}
+		size, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid chunk size %q", size)
+		}
+		if n == 0 {
+			// The trailers that follow the last chunk are not checked.
+			c.done = true
+			io.Copy(io.Discard, c.r)
+			continue
+		}
+		c.pending = int(n)
+	}
+
+	n, err := c.r.Read(p[:min(len(p), c.pending)])
+	c.pending -= n
+	if err == nil && c.pending == 0 {
+		// Each chunk's data ends with CRLF.
+		_, err = c.r.Discard(2)
+	}
+	return n, err
+}
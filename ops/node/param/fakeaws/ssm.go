@@ -0,0 +1,661 @@
+package fakeaws
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssmTargetPrefix = "AmazonSSM."
+	// defaultKeyID is the KMS key SSM encrypts a SecureString with when none
+	// is given.
+	defaultKeyID = "alias/aws/ssm"
+	// account is the account ID in every ARN the fake returns.
+	account = "123456789012"
+	// user is who the fake records as having modified each parameter.
+	user = "arn:aws:iam::" + account + ":user/fakeaws"
+)
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z_.-][A-Za-z0-9_.-]{0,99}$`)
+
+type parameter struct {
+	name string
+	// advanced is set once the parameter has been moved to the Advanced
+	// tier, which SSM never moves it back from.
+	advanced bool
+	versions []*version
+}
+
+type version struct {
+	number   int64
+	typ      string
+	keyID    string
+	value    string
+	dataType string
+	modified time.Time
+	labels   []string
+}
+
+func (p *parameter) latest() *version {
+	return p.versions[len(p.versions)-1]
+}
+
+func (p *parameter) tier() string {
+	if p.advanced {
+		return "Advanced"
+	}
+	return "Standard"
+}
+
+// SetParameter writes a new version of a String, StringList or SecureString
+// parameter, creating it if need be, and returns the version written. A
+// SecureString is encrypted with the default key.
+func (s *Server) SetParameter(name, typ, value string) int64 {
+	keyID := ""
+	if typ == "SecureString" {
+		keyID = defaultKeyID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(name, typ, value, keyID, "text")
+}
+
+// SetSecureString writes a new version of a SecureString parameter
+// encrypted with keyID, and returns the version written.
+func (s *Server) SetSecureString(name, value, keyID string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(name, "SecureString", value, keyID, "text")
+}
+
+// Parameter returns the latest value and version of a parameter, and
+// whether it exists.
+func (s *Server) Parameter(name string) (value string, version int64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.params[name]
+	if !ok {
+		return "", 0, false
+	}
+	v := p.latest()
+	return v.value, v.number, true
+}
+
+// ParameterKeyID returns the KMS key the latest version of a SecureString
+// parameter is encrypted with.
+func (s *Server) ParameterKeyID(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.params[name]; ok {
+		return p.latest().keyID
+	}
+	return ""
+}
+
+// ParameterTier returns the tier of a parameter, Standard or Advanced.
+func (s *Server) ParameterTier(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.params[name]; ok {
+		return p.tier()
+	}
+	return ""
+}
+
+// ParameterDataType returns the data type of the latest version of a
+// parameter, such as text or aws:ec2:image.
+func (s *Server) ParameterDataType(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.params[name]; ok {
+		return p.latest().dataType
+	}
+	return ""
+}
+
+// Labels returns the labels on one version of a parameter.
+func (s *Server) Labels(name string, number int64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.params[name]
+	if !ok || number < 1 || number > int64(len(p.versions)) {
+		return nil
+	}
+	return append([]string(nil), p.versions[number-1].labels...)
+}
+
+// put writes a new version of a parameter. s.mu must be held.
+func (s *Server) put(name, typ, value, keyID, dataType string) int64 {
+	p, ok := s.params[name]
+	if !ok {
+		p = &parameter{name: name}
+		s.params[name] = p
+	}
+	v := &version{
+		number:   int64(len(p.versions)) + 1,
+		typ:      typ,
+		keyID:    keyID,
+		value:    value,
+		dataType: dataType,
+		modified: s.now(),
+	}
+	p.versions = append(p.versions, v)
+	return v.number
+}
+
+// serveSSM answers one SSM JSON protocol request.
+func (s *Server) serveSSM(w http.ResponseWriter, r *http.Request, operation string) {
+	if e := s.failure(operation); e != nil {
+		writeSSMError(w, e)
+		return
+	}
+
+	handlers := map[string]func(*http.Request, json.RawMessage) (any, *Error){
+		"GetParameter":          s.getParameter,
+		"GetParameters":         s.getParameters,
+		"GetParametersByPath":   s.getParametersByPath,
+		"PutParameter":          s.putParameter,
+		"DeleteParameter":       s.deleteParameter,
+		"GetParameterHistory":   s.getParameterHistory,
+		"LabelParameterVersion": s.labelParameterVersion,
+		"DescribeParameters":    s.describeParameters,
+	}
+	handler, ok := handlers[operation]
+	if !ok {
+		writeSSMError(w, &Error{Status: http.StatusBadRequest, Code: "InvalidAction", Message: operation + " is not supported by fakeaws"})
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeSSMError(w, validationError("malformed request body: %v", err))
+		return
+	}
+	s.mu.Lock()
+	out, e := handler(r, body)
+	s.mu.Unlock()
+	if e != nil {
+		writeSSMError(w, e)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(out)
+}
+
+func writeSSMError(w http.ResponseWriter, e *Error) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]string{"__type": e.Code, "message": e.Message})
+}
+
+func validationError(format string, args ...any) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: "ValidationException", Message: fmt.Sprintf(format, args...)}
+}
+
+func decode(body json.RawMessage, v any) *Error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return validationError("malformed request body: %v", err)
+	}
+	return nil
+}
+
+// paramJSON is a Parameter in an SSM response.
+type paramJSON struct {
+	Name             string `json:"Name"`
+	Type             string `json:"Type"`
+	Value            string `json:"Value"`
+	Version          int64  `json:"Version"`
+	Selector         string `json:"Selector,omitempty"`
+	LastModifiedDate epoch  `json:"LastModifiedDate"`
+	ARN              string `json:"ARN"`
+	DataType         string `json:"DataType"`
+}
+
+// epoch is a timestamp as the SSM JSON protocol writes it, in seconds since
+// the Unix epoch.
+type epoch time.Time
+
+func (t epoch) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(time.Time(t).UnixMilli())/1000, 'f', 3, 64)), nil
+}
+
+func (s *Server) paramJSON(r *http.Request, p *parameter, v *version, selector string, decrypt bool) paramJSON {
+	return paramJSON{
+		Name:             p.name,
+		Type:             v.typ,
+		Value:            value(v, decrypt),
+		Version:          v.number,
+		Selector:         selector,
+		LastModifiedDate: epoch(v.modified),
+		ARN:              arn(r, p.name),
+		DataType:         v.dataType,
+	}
+}
+
+// value returns a version's value, or for a SecureString read without
+// decryption, a stand-in for its ciphertext.
+func value(v *version, decrypt bool) string {
+	if v.typ != "SecureString" || decrypt {
+		return v.value
+	}
+	return base64.StdEncoding.EncodeToString([]byte("fakeaws:" + v.keyID + ":" + v.value))
+}
+
+func arn(r *http.Request, name string) string {
+	return "arn:aws:ssm:" + region(r) + ":" + account + ":parameter/" + strings.TrimPrefix(name, "/")
+}
+
+// region returns the region a request was signed for.
+func region(r *http.Request) string {
+	_, credential, ok := strings.Cut(r.Header.Get("Authorization"), "Credential=")
+	if parts := strings.Split(credential, "/"); ok && len(parts) > 2 {
+		return parts[2]
+	}
+	return "us-east-1"
+}
+
+// resolve finds the version a name, name:version or name:label refers to.
+func (s *Server) resolve(ref string) (*parameter, *version, string, *Error) {
+	name, selector, _ := strings.Cut(ref, ":")
+	p, ok := s.params[name]
+	if !ok {
+		return nil, nil, "", &Error{Status: http.StatusBadRequest, Code: "ParameterNotFound", Message: "parameter " + name + " not found"}
+	}
+	if selector == "" {
+		return p, p.latest(), "", nil
+	}
+	notFound := &Error{Status: http.StatusBadRequest, Code: "ParameterVersionNotFound", Message: "version " + selector + " of " + name + " not found"}
+	if n, err := strconv.ParseInt(selector, 10, 64); err == nil {
+		if n < 1 || n > int64(len(p.versions)) {
+			return nil, nil, "", notFound
+		}
+		return p, p.versions[n-1], ":" + selector, nil
+	}
+	for _, v := range p.versions {
+		for _, label := range v.labels {
+			if label == selector {
+				return p, v, ":" + selector, nil
+			}
+		}
+	}
+	return nil, nil, "", notFound
+}
+
+func (s *Server) getParameter(r *http.Request, body json.RawMessage) (any, *Error) {
+	var in struct {
+		Name           string
+		WithDecryption bool
+	}
+	if e := decode(body, &in); e != nil {
+		return nil, e
+	}
+	p, v, selector, e := s.resolve(in.Name)
+	if e != nil {
+		return nil, e
+	}
+	return map[string]any{"Parameter": s.paramJSON(r, p, v, selector, in.WithDecryption)}, nil
+}
+
+func (s *Server) getParameters(r *http.Request, body json.RawMessage) (any, *Error) {
+	var in struct {
+		Names          []string
+		WithDecryption bool
+	}
+	if e := decode(body, &in); e != nil {
+		return nil, e
+	}
+	if len(in.Names) == 0 || len(in.Names) > 10 {
+		return nil, validationError("Names must hold 1 to 10 names")
+	}
+	params, invalid := []paramJSON{}, []string{}
+	for _, ref := range in.Names {
+		p, v, selector, e := s.resolve(ref)
+		if e != nil {
+			invalid = append(invalid, ref)
+			continue
+		}
+		params = append(params, s.paramJSON(r, p, v, selector, in.WithDecryption))
+	}
+	return map[string]any{"Parameters": params, "InvalidParameters": invalid}, nil
+}
+
+func (s *Server) getParametersByPath(r *http.Request, body json.RawMessage) (any, *Error) {
+	var in struct {
+		Path           string
+		Recursive      bool
+		WithDecryption bool
+		MaxResults     int
+		NextToken      string
+	}
+	if e := decode(body, &in); e != nil {
+		return nil, e
+	}
+	if !strings.HasPrefix(in.Path, "/") {
+		return nil, validationError("path %q must begin with /", in.Path)
+	}
+	option := "OneLevel"
+	if in.Recursive {
+		option = "Recursive"
+	}
+
+	var params []paramJSON
+	for _, p := range s.sorted() {
+		if underPath(p.name, in.Path, option) {
+			params = append(params, s.paramJSON(r, p, p.latest(), "", in.WithDecryption))
+		}
+	}
+	page, next, e := paginate(params, in.NextToken, in.MaxResults, 10)
+	if e != nil {
+		return nil, e
+	}
+	return pageJSON(page, next), nil
+}
+
+func (s *Server) putParameter(r *http.Request, body json.RawMessage) (any, *Error) {
+	var in struct {
+		Name      string
+		Value     *string
+		Type      string
+		KeyId     string
+		Overwrite bool
+		DataType  string
+		Tier      string
+	}
+	if e := decode(body, &in); e != nil {
+		return nil, e
+	}
+	if in.Name == "" || strings.Contains(in.Name, ":") || in.Value == nil {
+		return nil, validationError("a valid Name and a Value are required")
+	}
+
+	existing, exists := s.params[in.Name]
+	if exists && !in.Overwrite {
+		return nil, &Error{Status: http.StatusBadRequest, Code: "ParameterAlreadyExists", Message: "parameter " + in.Name + " already exists"}
+	}
+	typ, keyID, dataType := in.Type, in.KeyId, in.DataType
+	if exists {
+		latest := existing.latest()
+		typ = cmp.Or(typ, latest.typ)
+		if typ == latest.typ {
+			keyID = cmp.Or(keyID, latest.keyID)
+		}
+		dataType = cmp.Or(dataType, latest.dataType)
+	}
+	switch typ {
+	case "String", "StringList":
+		if keyID != "" && in.KeyId != "" {
+			return nil, validationError("KeyId is only valid for SecureString parameters")
+		}
+		keyID = ""
+	case "SecureString":
+		keyID = cmp.Or(keyID, defaultKeyID)
+	default:
+		return nil, validationError("Type must be String, StringList or SecureString, got %q", typ)
+	}
+	switch in.Tier {
+	case "", "Advanced", "Intelligent-Tiering":
+	case "Standard":
+		if exists && existing.advanced {
+			return nil, validationError("parameter %s uses the Advanced tier and can't be moved to the Standard tier", in.Name)
+		}
+	default:
+		return nil, validationError("Tier must be Standard, Advanced or Intelligent-Tiering, got %q", in.Tier)
+	}
+
+	number := s.put(in.Name, typ, *in.Value, keyID, cmp.Or(dataType, "text"))
+	p := s.params[in.Name]
+	p.advanced = p.advanced || in.Tier == "Advanced"
+	return map[string]any{"Version": number, "Tier": p.tier()}, nil
+}
+
+func (s *Server) deleteParameter(r *http.Request, body json.RawMessage) (any, *Error) {
+	var in struct{ Name string }
+	if e := decode(body, &in); e != nil {
+		return nil, e
+	}
+	if _, ok := s.params[in.Name]; !ok {
+		return nil, &Error{Status: http.StatusBadRequest, Code: "ParameterNotFound", Message: "parameter " + in.Name + " not found"}
+	}
+	delete(s.params, in.Name)
+	return struct{}{}, nil
+}
+
+func (s *Server) getParameterHistory(r *http.Request, body json.RawMessage) (any, *Error) {
+	var in struct {
+		Name           string
+		WithDecryption bool
+		MaxResults     int
+		NextToken      string
+	}
+	if e := decode(body, &in); e != nil {
+		return nil, e
+	}
+	p, ok := s.params[in.Name]
+	if !ok {
+		return nil, &Error{Status: http.StatusBadRequest, Code: "ParameterNotFound", Message: "parameter " + in.Name + " not found"}
+	}
+
+	type historyJSON struct {
+		Name             string   `json:"Name"`
+		Type             string   `json:"Type"`
+		KeyId            string   `json:"KeyId,omitempty"`
+		LastModifiedDate epoch    `json:"LastModifiedDate"`
+		LastModifiedUser string   `json:"LastModifiedUser"`
+		Value            string   `json:"Value"`
+		Version          int64    `json:"Version"`
+		Labels           []string `json:"Labels"`
+		DataType         string   `json:"DataType"`
+		Tier             string   `json:"Tier"`
+	}
+	history := make([]historyJSON, len(p.versions))
+	for i, v := range p.versions {
+		history[i] = historyJSON{
+			Name:             p.name,
+			Type:             v.typ,
+			KeyId:            v.keyID,
+			LastModifiedDate: epoch(v.modified),
+			LastModifiedUser: user,
+			Value:            value(v, in.WithDecryption),
+			Version:          v.number,
+			Labels:           append([]string{}, v.labels...),
+			DataType:         v.dataType,
+			Tier:             p.tier(),
+		}
+	}
+	page, next, e := paginate(history, in.NextToken, in.MaxResults, 50)
+	if e != nil {
+		return nil, e
+	}
+	return pageJSON(page, next), nil
+}
+
+func (s *Server) labelParameterVersion(r *http.Request, body json.RawMessage) (any, *Error) {
+	var in struct {
+		Name             string
+		ParameterVersion *int64
+		Labels           []string
+	}
+	if e := decode(body, &in); e != nil {
+		return nil, e
+	}
+	if len(in.Labels) == 0 || len(in.Labels) > 10 {
+		return nil, validationError("Labels must hold 1 to 10 labels")
+	}
+	ref := in.Name
+	if in.ParameterVersion != nil {
+		ref += ":" + strconv.FormatInt(*in.ParameterVersion, 10)
+	}
+	p, target, _, e := s.resolve(ref)
+	if e != nil {
+		return nil, e
+	}
+
+	invalid := []string{}
+	for _, label := range in.Labels {
+		lower := strings.ToLower(label)
+		if !labelPattern.MatchString(label) || strings.HasPrefix(lower, "aws") || strings.HasPrefix(lower, "ssm") {
+			invalid = append(invalid, label)
+			continue
+		}
+		// A label names one version at a time, so it moves off any other.
+		for _, v := range p.versions {
+			v.labels = remove(v.labels, label)
+		}
+		target.labels = append(target.labels, label)
+	}
+	return map[string]any{"InvalidLabels": invalid, "ParameterVersion": target.number}, nil
+}
+
+func (s *Server) describeParameters(r *http.Request, body json.RawMessage) (any, *Error) {
+	type filter struct {
+		Key    string
+		Option string
+		Values []string
+	}
+	var in struct {
+		ParameterFilters []filter
+		MaxResults       int
+		NextToken        string
+	}
+	if e := decode(body, &in); e != nil {
+		return nil, e
+	}
+
+	matches := func(p *parameter, f filter) (bool, *Error) {
+		for _, value := range f.Values {
+			switch {
+			case f.Key == "Name" && (f.Option == "" || f.Option == "Equals"):
+				if p.name == value {
+					return true, nil
+				}
+			case f.Key == "Name" && f.Option == "BeginsWith":
+				if strings.HasPrefix(p.name, value) {
+					return true, nil
+				}
+			case f.Key == "Path":
+				if underPath(p.name, value, cmp.Or(f.Option, "OneLevel")) {
+					return true, nil
+				}
+			case f.Key == "Type" && (f.Option == "" || f.Option == "Equals"):
+				if p.latest().typ == value {
+					return true, nil
+				}
+			default:
+				return false, validationError("filter %s with option %s is not supported by fakeaws", f.Key, f.Option)
+			}
+		}
+		return false, nil
+	}
+
+	type metadataJSON struct {
+		Name             string `json:"Name"`
+		ARN              string `json:"ARN"`
+		Type             string `json:"Type"`
+		KeyId            string `json:"KeyId,omitempty"`
+		LastModifiedDate epoch  `json:"LastModifiedDate"`
+		LastModifiedUser string `json:"LastModifiedUser"`
+		Version          int64  `json:"Version"`
+		DataType         string `json:"DataType"`
+		Tier             string `json:"Tier"`
+	}
+	var params []metadataJSON
+	for _, p := range s.sorted() {
+		ok := true
+		for _, f := range in.ParameterFilters {
+			match, e := matches(p, f)
+			if e != nil {
+				return nil, e
+			}
+			ok = ok && match
+		}
+		if !ok {
+			continue
+		}
+		v := p.latest()
+		params = append(params, metadataJSON{
+			Name:             p.name,
+			ARN:              arn(r, p.name),
+			Type:             v.typ,
+			KeyId:            v.keyID,
+			LastModifiedDate: epoch(v.modified),
+			LastModifiedUser: user,
+			Version:          v.number,
+			DataType:         v.dataType,
+			Tier:             p.tier(),
+		})
+	}
+	page, next, e := paginate(params, in.NextToken, in.MaxResults, 50)
+	if e != nil {
+		return nil, e
+	}
+	return pageJSON(page, next), nil
+}
+
+// sorted returns every parameter ordered by name. s.mu must be held.
+func (s *Server) sorted() []*parameter {
+	params := make([]*parameter, 0, len(s.params))
+	for _, p := range s.params {
+		params = append(params, p)
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].name < params[j].name })
+	return params
+}
+
+// underPath reports whether name is below path, directly for OneLevel or at
+// any depth for Recursive.
+func underPath(name, path, option string) bool {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	rest, ok := strings.CutPrefix(name, prefix)
+	if !ok || rest == "" {
+		return false
+	}
+	return option == "Recursive" || !strings.Contains(rest, "/")
+}
+
+// paginate returns the page of items that token starts at, at most
+// maxResults long or limit if that is unset, and the token of the next page.
+func paginate[T any](items []T, token string, maxResults, limit int) ([]T, string, *Error) {
+	if maxResults <= 0 || maxResults > limit {
+		maxResults = limit
+	}
+	start := 0
+	if token != "" {
+		n, err := strconv.Atoi(token)
+		if err != nil || n < 0 || n > len(items) {
+			return nil, "", &Error{Status: http.StatusBadRequest, Code: "InvalidNextToken", Message: "invalid NextToken"}
+		}
+		start = n
+	}
+	end := min(start+maxResults, len(items))
+	if end == len(items) {
+		return items[start:end], "", nil
+	}
+	return items[start:end], strconv.Itoa(end), nil
+}
+
+func remove(labels []string, label string) []string {
+	kept := labels[:0]
+	for _, l := range labels {
+		if l != label {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// pageJSON is a page of a paginated response.
+func pageJSON[T any](items []T, next string) map[string]any {
+	page := map[string]any{"Parameters": items}
+	if next != "" {
+		page["NextToken"] = next
+	}
+	return page
+}
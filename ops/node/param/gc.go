@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"jolli.ai/param/serverconfig"
+)
+
+// defaultKeep is how many installs are kept per server unless the --keep flag
+// or the server's KEEP setting says otherwise.
+const defaultKeep = 3
+
+// gc applies the retention policy to one or more server directories by hand.
+func gc(ctx context.Context, args []string) error {
+	const gcUsage = "param gc [--keep <n>] <server-dir>..."
+
+	fs := newFlagSet("gc")
+	keep := fs.Int("keep", defaultKeep, "number of installs to keep, besides current and the rollback target")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 || *keep < 1 {
+		return usageError(gcUsage)
+	}
+
+	for _, dir := range fs.Args() {
+		n, err := retention(dir, *keep)
+		if err != nil {
+			return err
+		}
+		if err := collectGarbage(dir, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retention returns how many installs to keep for a server: its KEEP setting
+// if it has one, and fallback otherwise.
+func retention(dir string, fallback int) (int, error) {
+	cfg, err := serverconfig.Load(dir)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Int("KEEP", fallback)
+}
+
+// collectGarbage deletes all but the keep most recently modified installs,
+// along with the downloaded tarballs of installs that no longer exist. The
+// current install, the last good one and the rollback target are always kept,
+// and don't count toward keep.
+func collectGarbage(dir string, keep int) error {
+	state, err := loadState(dir)
+	if err != nil {
+		return fmt.Errorf("reading deploy state: %w", err)
+	}
+	protected := map[string]bool{}
+	for _, install := range []string{state.Current, state.Good, state.Previous} {
+		if install != "" {
+			protected[filepath.Base(install)] = true
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dir, currentLink)); err == nil {
+		protected[filepath.Base(target)] = true
+	}
+
+	installs, err := visibleEntries(filepath.Join(dir, "installs"))
+	if err != nil {
+		return err
+	}
+	sort.Slice(installs, func(i, j int) bool { return installs[i].ModTime().After(installs[j].ModTime()) })
+
+	kept := map[string]bool{}
+	others := 0
+	for _, install := range installs {
+		name := install.Name()
+		if protected[name] {
+			kept[name] = true
+			continue
+		}
+		if others < keep {
+			others++
+			kept[name] = true
+			continue
+		}
+
+		log.Printf("%s: removing install %s", dir, name)
+		if err := os.RemoveAll(filepath.Join(dir, "installs", name)); err != nil {
+			return err
+		}
+	}
+
+	downloads, err := visibleEntries(filepath.Join(dir, "downloads"))
+	if err != nil {
+		return err
+	}
+	for _, download := range downloads {
+		name := download.Name()
+		if kept[strings.TrimSuffix(name, ".tgz")] {
+			continue
+		}
+
+		log.Printf("%s: removing download %s", dir, name)
+		if err := os.Remove(filepath.Join(dir, "downloads", name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visibleEntries lists a directory, skipping the dot-prefixed temporary files
+// and staging directories of deploys in progress. A missing directory has no
+// entries.
+func visibleEntries(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
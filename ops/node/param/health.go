@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"jolli.ai/param/serverconfig"
+)
+
+const (
+	defaultHealthTimeout = 2 * time.Minute
+	// defaultInstallTimeout bounds how long a restarted server may take to
+	// start its new app, which includes installing its dependencies.
+	defaultInstallTimeout = 15 * time.Minute
+	healthPollInterval    = time.Second
+	healthRequestTimeout  = 5 * time.Second
+)
+
+// checkHealth waits for a server's app to restart and pass its health check.
+// Servers without a HEALTH_URL pass as soon as they restart.
+func (d *deployer) checkHealth(ctx context.Context, dir string, oldPID int) error {
+	cfg, err := serverconfig.Load(dir)
+	if err != nil {
+		return err
+	}
+	installTimeout, err := cfg.Duration("INSTALL_TIMEOUT", d.installTimeout)
+	if err != nil {
+		return err
+	}
+	timeout, err := cfg.Duration("HEALTH_TIMEOUT", d.healthTimeout)
+	if err != nil {
+		return err
+	}
+
+	if err := waitStarted(ctx, dir, oldPID, installTimeout); err != nil {
+		return err
+	}
+	healthURL := cfg.Get("HEALTH_URL")
+	if healthURL == "" {
+		return nil
+	}
+	return waitHealthy(ctx, healthURL, timeout)
+}
+
+// waitStarted polls until an app process has replaced oldPID, or fails once
+// timeout has passed. The supervisor only starts the app once the install's
+// dependencies are installed, so this can take minutes. Waiting for the new
+// process first keeps the old one, which may still be answering, from
+// passing the health check on the new install's behalf.
+func waitStarted(ctx context.Context, dir string, oldPID int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		if pid := appPID(dir); pid != 0 && pid != oldPID {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("app did not start within %s", timeout)
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// waitHealthy polls until healthURL answers with a 2xx status, or fails once
+// timeout has passed.
+func waitHealthy(ctx context.Context, healthURL string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := &http.Client{Timeout: healthRequestTimeout}
+	for {
+		last := probe(ctx, client, healthURL)
+		if last == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not healthy after %s: %w", timeout, last)
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+func probe(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
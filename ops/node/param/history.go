@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// splitSelector splits a parameter reference such as /build/web/main:5 or
+// /build/web/main:prod into the parameter name and the version or label
+// after the colon. Parameter names can't contain colons, so a reference
+// without one has no selector.
+func splitSelector(ref string) (name, selector string, err error) {
+	name, selector, ok := strings.Cut(ref, ":")
+	if !ok {
+		return ref, "", nil
+	}
+	if name == "" || selector == "" || strings.Contains(selector, ":") {
+		return "", "", fmt.Errorf("%q is not a valid parameter reference; expected name, name:version or name:label", ref)
+	}
+	if selector[0] >= '0' && selector[0] <= '9' {
+		if v, err := strconv.ParseInt(selector, 10, 64); err != nil || v < 1 {
+			return "", "", fmt.Errorf("%q has an invalid version %q", ref, selector)
+		}
+	}
+	return name, selector, nil
+}
+
+// history prints every version of a parameter, oldest first, with when and
+// by whom it was written and its labels. Unless --show-secrets is given, a
+// SecureString version shows only whether its value changed from the version
+// before it, as even a hash would let someone confirm a guessed secret.
+func history(ctx context.Context, args []string) error {
+	const historyUsage = "param history [--show-secrets] [--output raw|json] [--region <region>] <name>"
+
+	fs := newFlagSet("history")
+	showSecrets := fs.Bool("show-secrets", false, "print SecureString values instead of whether they changed")
+	output := outputFlag(fs, outputRaw)
+	region := fs.String("region", "", "AWS region")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || (*output != outputRaw && *output != outputJSON) {
+		return usageError(historyUsage)
+	}
+	name, selector, err := splitSelector(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if selector != "" {
+		return fmt.Errorf("history covers every version of %s; drop the :%s", name, selector)
+	}
+
+	ssmSvc, err := newSSMClient(ctx, *region)
+	if err != nil {
+		return err
+	}
+
+	var versions []types.ParameterHistory
+	paginator := ssm.NewGetParameterHistoryPaginator(ssmSvc, &ssm.GetParameterHistoryInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("getting parameter history: %w", err)
+		}
+		versions = append(versions, page.Parameters...)
+	}
+
+	values := historyValues(versions, *showSecrets)
+	if *output == outputJSON {
+		records := make([]historyJSON, len(versions))
+		for i, p := range versions {
+			records[i] = historyJSON{
+				Name:             aws.ToString(p.Name),
+				Type:             string(p.Type),
+				Version:          p.Version,
+				DataType:         aws.ToString(p.DataType),
+				LastModified:     p.LastModifiedDate,
+				LastModifiedUser: aws.ToString(p.LastModifiedUser),
+				Labels:           p.Labels,
+				Value:            values[i],
+			}
+		}
+		return writeJSON(os.Stdout, records)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tMODIFIED\tUSER\tLABELS\tVALUE")
+	for i, p := range versions {
+		labels := strings.Join(p.Labels, ",")
+		if labels == "" {
+			labels = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			p.Version,
+			aws.ToTime(p.LastModifiedDate).UTC().Format(time.RFC3339),
+			aws.ToString(p.LastModifiedUser),
+			labels,
+			strconv.Quote(values[i]))
+	}
+	return w.Flush()
+}
+
+// historyJSON is one version of a parameter as printed by --output json.
+type historyJSON struct {
+	Name             string     `json:"name"`
+	Type             string     `json:"type"`
+	Version          int64      `json:"version"`
+	DataType         string     `json:"dataType,omitempty"`
+	LastModified     *time.Time `json:"lastModified,omitempty"`
+	LastModifiedUser string     `json:"lastModifiedUser,omitempty"`
+	Labels           []string   `json:"labels,omitempty"`
+	Value            string     `json:"value"`
+}
+
+// historyValues returns the value to show for each of versions, which are
+// oldest first. Unless showSecrets is set, a SecureString's value is
+// replaced by whether it differs from the version before it.
+func historyValues(versions []types.ParameterHistory, showSecrets bool) []string {
+	values := make([]string, len(versions))
+	for i, p := range versions {
+		value := aws.ToString(p.Value)
+		switch {
+		case p.Type != types.ParameterTypeSecureString || showSecrets:
+			values[i] = value
+		case i == 0:
+			values[i] = "(secret)"
+		case value == aws.ToString(versions[i-1].Value):
+			values[i] = "(secret, unchanged)"
+		default:
+			values[i] = "(secret, changed)"
+		}
+	}
+	return values
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHistoryHidesSecrets(t *testing.T) {
+	srv := newFakeAWS(t)
+	for _, value := range []string{"hunter2", "hunter2", "correct horse"} {
+		srv.SetParameter("/manager/prod/token/secret", "SecureString", value)
+	}
+
+	got, err := runCommand(t, history, "/manager/prod/token/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "hunter2") || strings.Contains(got, "correct horse") {
+		t.Errorf("history printed a secret:\n%s", got)
+	}
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	for i, want := range []string{"VALUE", `"(secret)"`, `"(secret, unchanged)"`, `"(secret, changed)"`} {
+		if i >= len(lines) || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("history line %d is %q, want it to end in %s", i, lines[min(i, len(lines)-1)], want)
+		}
+	}
+
+	if got, err := runCommand(t, history, "--show-secrets", "/manager/prod/token/secret"); err != nil || !strings.Contains(got, `"correct horse"`) {
+		t.Errorf("history --show-secrets printed %q, %v", got, err)
+	}
+}
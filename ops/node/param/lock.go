@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// lockPrefix is the path below which param keeps the lock of each parameter
+// it is writing. It is outside every path that is copied or rendered, so a
+// lock never shows up as a value.
+const lockPrefix = "/param/locks"
+
+// lockTTL is the age after which a lock is taken to have been left behind by
+// a writer that died, and is broken. No write holds its lock for more than a
+// few seconds.
+const lockTTL = 10 * time.Minute
+
+// A writer waiting for a lock checks it every lockPoll, for up to lockWait.
+var (
+	lockWait = 30 * time.Second
+	lockPoll = time.Second
+)
+
+// lockedError reports a parameter another writer still held the lock of
+// after lockWait.
+type lockedError struct {
+	name  string
+	lock  string
+	owner string
+}
+
+func (e lockedError) Error() string {
+	return fmt.Sprintf("%s is being written by %s; if that writer is gone, delete the lock parameter %s", e.name, e.owner, e.lock)
+}
+
+// lockParam serializes param's writers of a parameter, so that each one's
+// read of the current version, check and write happen without another
+// writer in between. The lock is a parameter created with Overwrite off,
+// which SSM creates for exactly one caller. The returned function deletes
+// it.
+//
+// A writer that may not create the lock parameter warns and goes ahead
+// without it. Its version checks still apply, so it still fails instead of
+// silently overwriting a change it didn't read; it just no longer waits its
+// turn.
+//
+// Breaking a stale lock is not atomic: two writers that find the same stale
+// lock at once could both go ahead. With a lockTTL far beyond any write,
+// that takes a writer dying and two more arriving within a poll of each
+// other ten minutes later.
+func lockParam(ctx context.Context, ssmSvc *ssm.Client, name string) (unlock func(), err error) {
+	lock := lockPrefix + name
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s pid %d", host, os.Getpid())
+	deadline := time.Now().Add(lockWait)
+	for {
+		_, err := ssmSvc.PutParameter(ctx, &ssm.PutParameterInput{
+			Name:      aws.String(lock),
+			Value:     aws.String(owner),
+			Type:      types.ParameterTypeString,
+			Overwrite: aws.Bool(false),
+		})
+		var exists *types.ParameterAlreadyExists
+		if err == nil {
+			return func() { unlockParam(ctx, ssmSvc, lock) }, nil
+		}
+		if classify(err) == classAccessDenied {
+			log.Printf("warning: writing %s without its lock: %v", name, err)
+			return func() {}, nil
+		}
+		if !errors.As(err, &exists) {
+			return nil, fmt.Errorf("locking %s: %w", name, err)
+		}
+
+		holder, err := getParameter(ctx, ssmSvc, lock, false)
+		var notFound *types.ParameterNotFound
+		switch {
+		case errors.As(err, &notFound):
+			// Released since the write; try again at once.
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("locking %s: %w", name, err)
+		case time.Since(aws.ToTime(holder.LastModifiedDate)) > lockTTL:
+			log.Printf("warning: breaking the lock on %s, held by %s since %s", name, aws.ToString(holder.Value), aws.ToTime(holder.LastModifiedDate).Format(time.RFC3339))
+			unlockParam(ctx, ssmSvc, lock)
+			continue
+		case time.Now().After(deadline):
+			return nil, lockedError{name: name, lock: lock, owner: aws.ToString(holder.Value)}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+}
+
+// unlockParam deletes a lock parameter, even if ctx has been canceled, so
+// an interrupted write doesn't leave its lock behind.
+func unlockParam(ctx context.Context, ssmSvc *ssm.Client, lock string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readTimeout)
+	defer cancel()
+	_, err := ssmSvc.DeleteParameter(ctx, &ssm.DeleteParameterInput{Name: aws.String(lock)})
+	var notFound *types.ParameterNotFound
+	if err != nil && !errors.As(err, &notFound) {
+		log.Printf("warning: deleting lock %s: %v", lock, err)
+	}
+}
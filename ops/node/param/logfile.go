@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogMaxSizeMB = 10
+	defaultLogMaxAge    = 24 * time.Hour
+	defaultLogKeep      = 5
+
+	logTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+	// maxLineLength bounds how much of a line without a newline is buffered
+	// before it is logged anyway.
+	maxLineLength = 64 << 10
+)
+
+// rotatingLog is an append-only log file that is rotated once it grows past
+// maxSize or was started more than maxAge ago. Rotated files are gzipped as
+// <path>.1.gz, <path>.2.gz and so on, newest first, and only the newest keep
+// of them are kept.
+type rotatingLog struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	started time.Time
+}
+
+func openRotatingLog(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingLog, error) {
+	l := &rotatingLog{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the log file for appending. The age of a file that already
+// exists is counted from when it is opened, since its creation time isn't
+// portably available.
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.started = f, info.Size(), time.Now()
+	return nil
+}
+
+// writeLine writes one line prefixed with a timestamp and the name of the
+// stream it came from, rotating the file first if it is due.
+func (l *rotatingLog) writeLine(stream string, line []byte) {
+	var b bytes.Buffer
+	b.WriteString(time.Now().UTC().Format(logTimeFormat))
+	b.WriteByte(' ')
+	b.WriteString(stream)
+	b.WriteByte(' ')
+	b.Write(bytes.TrimRight(line, "\r\n"))
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && (l.size+int64(b.Len()) > l.maxSize || time.Since(l.started) > l.maxAge) {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "rotating %s: %v\n", l.path, err)
+		}
+	}
+	n, _ := l.f.Write(b.Bytes())
+	l.size += int64(n)
+}
+
+// marker writes a line that stands out from the app's own output, used to
+// show where the app started and stopped.
+func (l *rotatingLog) marker(format string, args ...any) {
+	l.writeLine("param", []byte("===== "+fmt.Sprintf(format, args...)+" ====="))
+}
+
+// rotate compresses the current file into <path>.1.gz, shifting older files
+// up and dropping the oldest, and starts a new file.
+func (l *rotatingLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(l.rotated(l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		if err := os.Rename(l.rotated(i), l.rotated(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	compressErr := compressFile(l.path, l.rotated(1))
+	if compressErr == nil {
+		compressErr = os.Remove(l.path)
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	return compressErr
+}
+
+func (l *rotatingLog) rotated(n int) string {
+	return fmt.Sprintf("%s.%d.gz", l.path, n)
+}
+
+func compressFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func (l *rotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// stream returns a writer that splits what is written to it into lines and
+// logs each one under the given stream name.
+func (l *rotatingLog) stream(name string) *lineWriter {
+	return &lineWriter{log: l, stream: name}
+}
+
+// lineWriter buffers partial lines until they are complete.
+type lineWriter struct {
+	log    *rotatingLog
+	stream string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log.writeLine(w.stream, w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxLineLength {
+		w.log.writeLine(w.stream, w.buf)
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Flush logs any partial line left over once the writer is done with.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.log.writeLine(w.stream, w.buf)
+		w.buf = nil
+	}
+}
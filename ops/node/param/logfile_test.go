@@ -0,0 +1,86 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLog returns the contents of a log file, decompressing a rotated one.
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		r = gz
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestRotatingLogRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), logFile)
+	// Each line is longer than half of maxSize, so each file holds one.
+	l, err := openRotatingLog(path, 100, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 1; i <= 5; i++ {
+		l.writeLine("stdout", []byte(fmt.Sprintf("line %d %s", i, strings.Repeat("x", 40))))
+	}
+
+	for name, want := range map[string]string{
+		logFile:           "line 5",
+		logFile + ".1.gz": "line 4",
+		logFile + ".2.gz": "line 3",
+	} {
+		got := readLog(t, filepath.Join(filepath.Dir(path), name))
+		if strings.Count(got, "\n") != 1 || !strings.Contains(got, " stdout "+want+" ") {
+			t.Errorf("%s holds %q, want only %s", name, got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3.gz"); err == nil {
+		t.Error("kept more rotated logs than keep")
+	}
+}
+
+func TestRotatingLogRotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), logFile)
+	l, err := openRotatingLog(path, 1<<20, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.writeLine("stdout", []byte("first"))
+	l.writeLine("stdout", []byte("second"))
+	if _, err := os.Stat(path + ".1.gz"); err == nil {
+		t.Fatal("rotated a log that is neither too big nor too old")
+	}
+
+	l.started = time.Now().Add(-2 * time.Hour)
+	l.writeLine("stdout", []byte("third"))
+	if got := readLog(t, path+".1.gz"); !strings.Contains(got, "first") || !strings.Contains(got, "second") || strings.Contains(got, "third") {
+		t.Errorf("rotated log holds %q, want the first two lines", got)
+	}
+	if got := readLog(t, path); !strings.HasSuffix(got, " stdout third\n") || strings.Count(got, "\n") != 1 {
+		t.Errorf("new log holds %q, want only the third line", got)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"jolli.ai/param/serverconfig"
+)
+
+// managerEnvFile is where manager-update renders each environment's secrets,
+// inside its server directory.
+const managerEnvFile = ".env"
+
+// managerConfig is the JSON file that lists the environments a manager host
+// runs.
+type managerConfig struct {
+	// Region defaults to AWS_REGION, then the region the host runs in.
+	Region string `json:"region"`
+	// FallbackRegions are read, in order, when the primary region's SSM
+	// endpoint is unavailable.
+	FallbackRegions []string             `json:"fallbackRegions"`
+	Environments    []managerEnvironment `json:"environments"`
+}
+
+// managerEnvironment is one copy of the manager app, deployed into its own
+// server directory the same way param sync deploys a node server.
+type managerEnvironment struct {
+	Name string `json:"name"`
+	// Dir is the server directory the environment is installed in.
+	Dir string `json:"dir"`
+	// Build is the parameter holding the S3 URL of the build to run.
+	Build string `json:"build"`
+	// Secrets is the parameter path rendered into the app's .env.
+	Secrets string `json:"secrets"`
+	// Port is the app's PORT.
+	Port int `json:"port"`
+	// Env holds static variables for the .env, overriding secrets.
+	Env map[string]string `json:"env"`
+	// Workdir and Start are the server's WORKDIR and START settings.
+	Workdir string `json:"workdir"`
+	Start   string `json:"start"`
+	// Config holds any other .config settings, such as HEALTH_URL or KEEP.
+	Config map[string]string `json:"config"`
+}
+
+// managerUpdate deploys every environment in a manager config once: it
+// renders each environment's .config and .env, deploys its build if the build
+// parameter changed, and restarts the app if only its .env did. It replaces
+// manager-updater.sh and is meant to be run on a timer.
+func managerUpdate(ctx context.Context, args []string) error {
+	const managerUsage = "param manager-update [--keep <n>] [--install-timeout <duration>] [--health-timeout <duration>] <config.json>"
+
+	fs := newFlagSet("manager-update")
+	keep := fs.Int("keep", defaultKeep, "number of installs to keep, besides current and the rollback target")
+	installTimeout := fs.Duration("install-timeout", defaultInstallTimeout, "time a restarted server has to install dependencies and start its new app")
+	healthTimeout := fs.Duration("health-timeout", defaultHealthTimeout, "time a new install has to pass its health check once its app has started")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || *keep < 1 {
+		return usageError(managerUsage)
+	}
+
+	mc, err := loadManagerConfig(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadAWSConfig(ctx, mc.Region)
+	if err != nil {
+		return err
+	}
+	ssmSvcs, err := newSSMClients(ctx, cfg.Region, strings.Join(mc.FallbackRegions, ","))
+	if err != nil {
+		return err
+	}
+	s := &syncer{
+		ssmSvcs:  ssmSvcs,
+		deployer: &deployer{s3Svc: newS3(cfg), keep: *keep, installTimeout: *installTimeout, healthTimeout: *healthTimeout},
+		failed:   map[string]failedDeploy{},
+	}
+
+	var errs []error
+	for _, e := range mc.Environments {
+		if err := s.updateEnvironment(ctx, cfg.Region, e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func loadManagerConfig(path string) (*managerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mc managerConfig
+	if err := json.Unmarshal(data, &mc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, e := range mc.Environments {
+		if e.Name == "" || e.Dir == "" || e.Build == "" || e.Port == 0 {
+			return nil, fmt.Errorf("%s: environment %d needs a name, dir, build and port", path, i+1)
+		}
+	}
+	return &mc, nil
+}
+
+// updateEnvironment renders one environment's server directory and runs a
+// single sync pass over it.
+func (s *syncer) updateEnvironment(ctx context.Context, region string, e managerEnvironment) error {
+	if err := os.MkdirAll(e.Dir, 0755); err != nil {
+		return err
+	}
+
+	settings := map[string]string{}
+	for key, value := range e.Config {
+		settings[key] = value
+	}
+	settings["BUILD"] = e.Build
+	settings["ENV"] = filepath.Join(e.Dir, managerEnvFile)
+	if e.Workdir != "" {
+		settings["WORKDIR"] = e.Workdir
+	}
+	if e.Start != "" {
+		settings["START"] = e.Start
+	}
+	config, err := renderConfig(settings)
+	if err != nil {
+		return fmt.Errorf("rendering %s: %w", serverconfig.FileName, err)
+	}
+	if _, err := writeIfChanged(filepath.Join(e.Dir, serverconfig.FileName), config, 0644); err != nil {
+		return err
+	}
+
+	var secrets []envVar
+	if e.Secrets != "" {
+		if secrets, err = cachedEnvVarsByPath(ctx, nil, s.ssmSvcs, e.Secrets, true); err != nil {
+			return err
+		}
+	}
+	extra := map[string]string{"AWS_REGION": region}
+	for name, value := range e.Env {
+		extra[name] = value
+	}
+	extra["PORT"] = strconv.Itoa(e.Port)
+	env, err := renderDotenv(secrets, extra)
+	if err != nil {
+		return fmt.Errorf("rendering %s: %w", managerEnvFile, err)
+	}
+	envChanged, err := writeIfChanged(settings["ENV"], env, 0600)
+	if err != nil {
+		return err
+	}
+
+	before, err := loadState(e.Dir)
+	if err != nil {
+		return fmt.Errorf("reading deploy state: %w", err)
+	}
+	if err := s.syncServer(ctx, e.Dir); err != nil {
+		return err
+	}
+	after, err := loadState(e.Dir)
+	if err != nil {
+		return fmt.Errorf("reading deploy state: %w", err)
+	}
+
+	// A deploy restarts the app with the new .env already; otherwise a
+	// changed .env needs a restart of its own.
+	if envChanged && after.URL == before.URL && after.Current != "" {
+		log.Printf("%s: %s changed, restarting", e.Dir, managerEnvFile)
+		return restartServer(e.Dir)
+	}
+	return nil
+}
+
+// renderConfig formats settings as a .config file, one single-quoted
+// assignment per line sorted by key, in the shell syntax serverconfig reads.
+func renderConfig(settings map[string]string) ([]byte, error) {
+	var b strings.Builder
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		value := settings[key]
+		if !envVarNamePattern.MatchString(key) {
+			return nil, fmt.Errorf("%q is not a valid setting name", key)
+		}
+		if strings.ContainsAny(value, "\n\r") {
+			return nil, fmt.Errorf("%s: a setting can't span lines", key)
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(shellQuote(value))
+		b.WriteByte('\n')
+	}
+	return []byte(b.String()), nil
+}
+
+// writeIfChanged writes data to path unless the file already holds exactly
+// that, and reports whether it wrote.
+func writeIfChanged(path string, data []byte, perm os.FileMode) (bool, error) {
+	old, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	if err == nil && bytes.Equal(old, data) {
+		return false, nil
+	}
+	if err := writeFileAtomic(path, data, perm); err != nil {
+		return false, fmt.Errorf("writing %s: %w", path, err)
+	}
+	return true, nil
+}
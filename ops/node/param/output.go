@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// outputFormat is the --output flag of the commands that read parameters.
+type outputFormat string
+
+const (
+	// outputRaw is each command's plain, human-oriented output.
+	outputRaw outputFormat = "raw"
+	// outputJSON includes each parameter's metadata as well as its value.
+	outputJSON outputFormat = "json"
+	// outputShell is `export NAME='value'` lines for a shell to eval.
+	outputShell outputFormat = "shell"
+	// outputDotenv is NAME='value' lines in the format param env writes.
+	outputDotenv outputFormat = "dotenv"
+)
+
+func (f *outputFormat) String() string {
+	return string(*f)
+}
+
+func (f *outputFormat) Set(s string) error {
+	switch format := outputFormat(s); format {
+	case outputRaw, outputJSON, outputShell, outputDotenv:
+		*f = format
+		return nil
+	}
+	return fmt.Errorf("output must be raw, json, shell or dotenv, got %q", s)
+}
+
+// outputFlag registers --output on a command's flag set.
+func outputFlag(fs *flag.FlagSet, value outputFormat) *outputFormat {
+	fs.Var(&value, "output", "output format: raw, json, shell or dotenv")
+	return &value
+}
+
+// paramJSON is a parameter as printed by --output json.
+type paramJSON struct {
+	Name         string     `json:"name"`
+	Type         string     `json:"type"`
+	Version      int64      `json:"version"`
+	ARN          string     `json:"arn,omitempty"`
+	DataType     string     `json:"dataType,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Selector     string     `json:"selector,omitempty"`
+	// EnvName is the environment variable the parameter is rendered as by
+	// get-by-path and env.
+	EnvName string `json:"envName,omitempty"`
+	Value   string `json:"value"`
+}
+
+func newParamJSON(p types.Parameter) paramJSON {
+	return paramJSON{
+		Name:         aws.ToString(p.Name),
+		Type:         string(p.Type),
+		Version:      p.Version,
+		ARN:          aws.ToString(p.ARN),
+		DataType:     aws.ToString(p.DataType),
+		LastModified: p.LastModifiedDate,
+		Selector:     aws.ToString(p.Selector),
+		Value:        aws.ToString(p.Value),
+	}
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeEnvVars prints vars in the shell or dotenv format.
+func writeEnvVars(w io.Writer, vars []envVar, format outputFormat) error {
+	render := func(vars []envVar) ([]byte, error) { return renderDotenv(vars, nil) }
+	if format == outputShell {
+		render = renderShell
+	}
+	data, err := render(vars)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// renderShell formats vars as export lines with single-quoted values, which
+// a shell takes literally.
+func renderShell(vars []envVar) ([]byte, error) {
+	var b strings.Builder
+	for _, v := range vars {
+		if !envVarNamePattern.MatchString(v.Name) {
+			return nil, fmt.Errorf("%q is not a valid environment variable name", v.Name)
+		}
+		b.WriteString("export ")
+		b.WriteString(v.Name)
+		b.WriteByte('=')
+		b.WriteString(shellQuote(v.Value))
+		b.WriteByte('\n')
+	}
+	return []byte(b.String()), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
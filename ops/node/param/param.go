@@ -2,37 +2,319 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
 )
 
+const usage = `Usage: param [--timeout <duration>] [--endpoint-url <url>] <command> ...
+
+  param [get] [--decrypt] [--output raw|json|shell|dotenv] [--region <region>] [--fallback-regions <region>,...] [--max-stale <duration>] [<region>] <parameter-name>[:<version>|:<label>]
+  param get-by-path [--decrypt=false] [--output raw|json|shell|dotenv] [--region <region>] [--fallback-regions <region>,...] [--max-stale <duration>] [<region>] <path>
+  param env --prefix <path> [--out <file>] [--output dotenv|shell|json] [--set KEY=VALUE]... [--region <region>] [--fallback-regions <region>,...] [--decrypt=false] [--max-stale <duration>]
+  param config <server-dir> <key>
+  param sync [--region <region>] [--servers <dir>] [--interval <duration>] [--keep <n>] [--health-timeout <duration>] [--max-stale <duration>] [--fallback-regions <region>,...]
+  param run [--stop-timeout <duration>] [--log-max-size <MB>] [--log-max-age <duration>] [--log-keep <n>] <server-dir>
+  param restart <server-dir>...
+  param rollback <server-dir>
+  param gc [--keep <n>] <server-dir>...
+  param manager-update [--keep <n>] [--health-timeout <duration>] <config.json>
+  param copy [--path] [--if-changed] [--expect-version <n>] [--region <region>] <src> <dst>
+  param history [--show-secrets] [--output raw|json] [--region <region>] <name>
+  param promote [--region <region>] <path> --from <branch> --to <branch>
+  param promote [--region <region>] --undo <path> --to <branch>
+  param region [--region <region>]
+  param publish --bucket <bucket> --dist <dir>... [--branch <branch>] [--region <region>]`
+
+// A command runs one param subcommand with the arguments that follow its name.
+type command func(ctx context.Context, args []string) error
+
+var commands = map[string]command{
+	"config":         configValue,
+	"copy":           copyParams,
+	"env":            env,
+	"gc":             gc,
+	"get":            get,
+	"get-by-path":    getByPath,
+	"history":        history,
+	"manager-update": managerUpdate,
+	"promote":        promote,
+	"publish":        publish,
+	"region":         region,
+	"restart":        restart,
+	"rollback":       rollback,
+	"run":            run,
+	"sync":           syncServers,
+}
+
+// usageError reports a malformed command line.
+type usageError string
+
+func (e usageError) Error() string {
+	return "usage: " + string(e)
+}
+
+// encryptedError reports a SecureString that was read without decryption, so
+// its value is ciphertext rather than the secret.
+type encryptedError struct {
+	name string
+}
+
+func (e encryptedError) Error() string {
+	return fmt.Sprintf("parameter %s is of type %s and was read without decryption; pass --decrypt", e.name, types.ParameterTypeSecureString)
+}
+
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: param <region> <parameter-name>")
-		os.Exit(1)
+	global, args, err := parseGlobalFlags(os.Args[1:])
+	if err == nil {
+		endpoints, err = loadEndpoints(global.endpointURL)
+	}
+	if err != nil || len(args) < 1 {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(int(classUsage))
+	}
+
+	run := get
+	if cmd, ok := commands[args[0]]; ok {
+		run, args = cmd, args[1:]
+	}
+
+	// SIGINT and SIGTERM cancel whatever AWS call or command is in flight.
+	// The long-running commands take cancellation as the signal to stop.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	cancel := context.CancelFunc(func() {})
+	if global.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, global.timeout)
+	}
+	err = run(ctx, args)
+	cancel()
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(int(classify(err)))
+	}
+}
+
+// globalFlags are the flags that may come before the command name.
+type globalFlags struct {
+	timeout     time.Duration
+	endpointURL string
+}
+
+// parseGlobalFlags parses the flags that may come before the command name
+// and returns the remaining arguments. Flags after the command name belong
+// to the command, including the bare `param --decrypt <region> <name>` form
+// of get.
+func parseGlobalFlags(args []string) (globalFlags, []string, error) {
+	var global globalFlags
+	fs := newFlagSet("param")
+	fs.DurationVar(&global.timeout, "timeout", 0, "deadline for the whole command, including retries")
+	fs.StringVar(&global.endpointURL, "endpoint-url", "", "URL to send SSM and S3 requests to instead of AWS")
+
+	n := 0
+	for n < len(args) {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[n], "-"), "=")
+		if !strings.HasPrefix(args[n], "-") || fs.Lookup(name) == nil {
+			break
+		}
+		n++
+		if !hasValue {
+			n++
+		}
+	}
+
+	n = min(n, len(args))
+	if err := fs.Parse(args[:n]); err != nil {
+		return global, nil, err
+	}
+	if global.timeout < 0 {
+		return global, nil, fmt.Errorf("timeout must not be negative")
+	}
+	return global, args[n:], nil
+}
+
+// stopped returns nil if ctx was canceled, which is how SIGINT and SIGTERM
+// ask a long-running command to stop, and ctx's error otherwise, such as
+// the --timeout deadline passing.
+func stopped(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// newFlagSet returns a flag set for a subcommand that reports parse errors to
+// the caller instead of exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// Every AWS call is attempted up to maxAttempts times, waiting a random
+// time of up to maxBackoff between attempts.
+const (
+	maxAttempts = 5
+	maxBackoff  = 20 * time.Second
+)
+
+// loadAWSConfig loads the default AWS configuration. An empty region falls
+// back to the SDK's usual lookup through AWS_REGION and the shared config,
+// and then to the region of the EC2 instance param is running on.
+// With an endpoint override and no credentials in the environment, static
+// test credentials are used, as a local emulator doesn't check them.
+func loadAWSConfig(ctx context.Context, region string) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{config.WithRegion(region), config.WithRetryer(newRetryer)}
+	if endpoints.overridden() && os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(testAccessKeyID, testSecretAccessKey, "")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return cfg, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	switch {
+	case cfg.Region != "":
+	case endpoints.overridden():
+		cfg.Region = testRegion
+	default:
+		if cfg.Region, err = imdsRegion(ctx, cfg); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// newRetryer returns the SDK's standard retryer, which retries throttling,
+// 5xx responses and network errors with jittered exponential backoff, with
+// two changes. Errors that can't succeed on another attempt, such as
+// AccessDenied and ParameterNotFound, are final whatever their status code.
+// And there is no client-side retry quota, which a sync daemon polling
+// every second would otherwise use up during an outage and then fail calls
+// that were never sent.
+func newRetryer() aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = maxAttempts
+		o.MaxBackoff = maxBackoff
+		o.RateLimiter = ratelimit.None
+		o.Retryables = append([]retry.IsErrorRetryable{retry.IsErrorRetryableFunc(finalError)}, o.Retryables...)
+	})
+}
+
+// finalError reports the errors that are never worth retrying.
+func finalError(err error) aws.Ternary {
+	switch classify(err) {
+	case classNotFound, classAccessDenied, classDecrypt:
+		return aws.FalseTernary
 	}
+	return aws.UnknownTernary
+}
 
-	region := os.Args[1]
-	paramName := os.Args[2]
+// regionArg returns the single argument of a read command, which may be
+// preceded by a region, as in `param us-west-2 <name>`. A region given that
+// way is stored in region, and may not also be given with --region.
+func regionArg(fs *flag.FlagSet, region *string) (string, error) {
+	switch {
+	case fs.NArg() == 1:
+		return fs.Arg(0), nil
+	case fs.NArg() == 2 && *region == "":
+		*region = fs.Arg(0)
+		return fs.Arg(1), nil
+	}
+	return "", errors.New("want one argument, optionally preceded by a region")
+}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+func newSSMClient(ctx context.Context, region string) (*ssm.Client, error) {
+	cfg, err := loadAWSConfig(ctx, region)
 	if err != nil {
-		fmt.Println("Error loading AWS configuration:", err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	ssmSvc := ssm.NewFromConfig(cfg)
+	return newSSM(cfg), nil
+}
+
+// get prints the value of a single parameter. As shell or dotenv output it
+// is named after the whole parameter path, so /build/jolli-web/main becomes
+// BUILD_JOLLI_WEB_MAIN.
+func get(ctx context.Context, args []string) error {
+	const getUsage = "param [get] [--decrypt] [--output raw|json|shell|dotenv] [--region <region>] [--fallback-regions <region>,...] [--max-stale <duration>] [<region>] <parameter-name>[:<version>|:<label>]"
 
-	param, err := ssmSvc.GetParameter(context.Background(), &ssm.GetParameterInput{
-		Name: &paramName,
+	fs := newFlagSet("get")
+	decrypt := fs.Bool("decrypt", false, "decrypt SecureString values")
+	output := outputFlag(fs, outputRaw)
+	region := fs.String("region", "", "AWS region")
+	fallbacks := fallbackFlag(fs)
+	maxStale := cacheFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return usageError(getUsage)
+	}
+	name, err := regionArg(fs, region)
+	if err != nil {
+		return usageError(getUsage)
+	}
+
+	ssmSvcs, err := newSSMClients(ctx, *region, *fallbacks)
+	if err != nil {
+		return err
+	}
+
+	param, err := cachedParameter(ctx, openCache(*maxStale), ssmSvcs, name, *decrypt)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case outputJSON:
+		return writeJSON(os.Stdout, newParamJSON(*param))
+	case outputShell, outputDotenv:
+		name := pathToEnvVarName(strings.Trim(aws.ToString(param.Name), "/"))
+		return writeEnvVars(os.Stdout, []envVar{{Name: name, Value: *param.Value, Param: param}}, *output)
+	}
+	fmt.Println(*param.Value)
+	return nil
+}
+
+// getParameter reads a single parameter. The name may pin a version or label
+// as name:5 or name:label. A SecureString read without decryption is
+// reported as an encryptedError rather than returning its ciphertext as
+// though it were the value.
+func getParameter(ctx context.Context, ssmSvc *ssm.Client, name string, decrypt bool) (*types.Parameter, error) {
+	if _, _, err := splitSelector(name); err != nil {
+		return nil, err
+	}
+	out, err := ssmSvc.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(decrypt),
 	})
 	if err != nil {
-		fmt.Println("Error getting parameter:", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("getting parameter: %w", err)
 	}
 
-	fmt.Println(*param.Parameter.Value)
+	if err := checkDecrypted(out.Parameter, decrypt); err != nil {
+		return nil, err
+	}
+	return out.Parameter, nil
+}
+
+func checkDecrypted(p *types.Parameter, decrypt bool) error {
+	if !decrypt && p.Type == types.ParameterTypeSecureString {
+		return encryptedError{name: aws.ToString(p.Name)}
+	}
+	return nil
 }
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"jolli.ai/param/fakeaws"
+)
+
+// newFakeAWS starts a fake SSM and S3 and points param at it as an endpoint
+// override, which brings static test credentials with it, so commands run
+// without AWS. The cache goes to a temporary directory.
+func newFakeAWS(t *testing.T) *fakeaws.Server {
+	t.Helper()
+	srv := fakeaws.New()
+	t.Cleanup(srv.Close)
+	endpoints = awsEndpoints{ssm: srv.URL, s3: srv.URL}
+	t.Cleanup(func() { endpoints = awsEndpoints{} })
+
+	dir := t.TempDir()
+	t.Setenv("AWS_REGION", "us-west-2")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("PARAM_CACHE_DIR", filepath.Join(dir, "cache"))
+	return srv
+}
+
+// runCommand runs a param command and returns what it printed to stdout.
+func runCommand(t *testing.T, cmd command, args ...string) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- data
+	}()
+	err = cmd(context.Background(), args)
+	w.Close()
+	return string(<-out), err
+}
+
+func TestGet(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/build/jolli-web/main", "String", "s3://builds/web-1.tgz")
+	srv.SetParameter("/build/jolli-web/main", "String", "s3://builds/web-2.tgz")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"us-west-2", "/build/jolli-web/main"}, "s3://builds/web-2.tgz\n"},
+		{[]string{"us-west-2", "/build/jolli-web/main:1"}, "s3://builds/web-1.tgz\n"},
+		{[]string{"/build/jolli-web/main"}, "s3://builds/web-2.tgz\n"},
+		{[]string{"--region", "us-west-2", "/build/jolli-web/main"}, "s3://builds/web-2.tgz\n"},
+		{[]string{"--output", "shell", "us-west-2", "/build/jolli-web/main"}, "export BUILD_JOLLI_WEB_MAIN='s3://builds/web-2.tgz'\n"},
+		{[]string{"--output", "dotenv", "us-west-2", "/build/jolli-web/main"}, "BUILD_JOLLI_WEB_MAIN='s3://builds/web-2.tgz'\n"},
+	}
+	for _, tt := range tests {
+		got, err := runCommand(t, get, tt.args...)
+		if err != nil || got != tt.want {
+			t.Errorf("get %v = %q, %v; want %q", tt.args, got, err, tt.want)
+		}
+	}
+
+	got, err := runCommand(t, get, "--output", "json", "us-west-2", "/build/jolli-web/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p paramJSON
+	if err := json.Unmarshal([]byte(got), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "/build/jolli-web/main" || p.Version != 2 || p.Type != "String" || p.ARN == "" || p.Value != "s3://builds/web-2.tgz" {
+		t.Errorf("got %+v", p)
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/manager/prod/token", "SecureString", "hunter2")
+
+	tests := []struct {
+		args  []string
+		class errorClass
+	}{
+		{[]string{}, classUsage},
+		{[]string{"us-west-2", "/manager", "/manager/prod/token"}, classUsage},
+		{[]string{"--region", "us-west-2", "us-west-2", "/manager/prod/token"}, classUsage},
+		{[]string{"us-west-2", "/missing"}, classNotFound},
+		{[]string{"us-west-2", "/manager/prod/token:7"}, classNotFound},
+		{[]string{"us-west-2", "/manager/prod/token"}, classDecrypt},
+	}
+	for _, tt := range tests {
+		got, err := runCommand(t, get, tt.args...)
+		if class := classify(err); class != tt.class || got != "" {
+			t.Errorf("get %v printed %q and failed with %v (%s), want %s", tt.args, got, err, class, tt.class)
+		}
+	}
+
+	got, err := runCommand(t, get, "--decrypt", "us-west-2", "/manager/prod/token")
+	if err != nil || got != "hunter2\n" {
+		t.Errorf("get --decrypt = %q, %v", got, err)
+	}
+}
+
+func TestGetByPathAndEnv(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/manager/prod/db/host", "String", "db.internal")
+	srv.SetParameter("/manager/prod/token/secret", "SecureString", "it's secret")
+
+	got, err := runCommand(t, getByPath, "us-west-2", "/manager/prod")
+	if want := "DB_HOST=db.internal\nTOKEN_SECRET=it's secret\n"; err != nil || got != want {
+		t.Errorf("get-by-path = %q, %v; want %q", got, err, want)
+	}
+
+	out := filepath.Join(t.TempDir(), "prod.env")
+	if _, err := runCommand(t, env, "--prefix", "/manager/prod", "--set", "PORT=8034", "--out", out); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "DB_HOST='db.internal'\nPORT='8034'\nTOKEN_SECRET=`it's secret`\n"
+	if string(data) != want {
+		t.Errorf("env wrote %q, want %q", data, want)
+	}
+	if info, _ := os.Stat(out); info.Mode().Perm() != 0600 {
+		t.Errorf("env wrote mode %v", info.Mode().Perm())
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err   error
+		class errorClass
+	}{
+		{nil, 0},
+		{errors.New("boom"), classOther},
+		{usageError("param get"), classUsage},
+		{encryptedError{name: "/x"}, classDecrypt},
+		{context.DeadlineExceeded, classTransient},
+	}
+	for _, tt := range tests {
+		if got := classify(tt.err); got != tt.class {
+			t.Errorf("classify(%v) = %s, want %s", tt.err, got, tt.class)
+		}
+	}
+}
+
+func TestParseGlobalFlags(t *testing.T) {
+	global, args, err := parseGlobalFlags([]string{"--timeout", "30s", "--endpoint-url=http://localhost:4566", "--decrypt", "us-west-2", "/x"})
+	if err != nil || global.timeout != 30*time.Second || global.endpointURL != "http://localhost:4566" || len(args) != 3 || args[0] != "--decrypt" {
+		t.Errorf("got %+v, %v, %v", global, args, err)
+	}
+	if _, _, err := parseGlobalFlags([]string{"--timeout"}); err == nil {
+		t.Error("--timeout without a value was accepted")
+	}
+}
+
+func TestLoadEndpoints(t *testing.T) {
+	t.Setenv("PARAM_SSM_ENDPOINT", "http://localhost:4566")
+	t.Setenv("PARAM_S3_ENDPOINT", "")
+	e, err := loadEndpoints("")
+	if err != nil || e.ssm != "http://localhost:4566" || e.s3 != "" {
+		t.Errorf("got %+v, %v", e, err)
+	}
+	if e, err := loadEndpoints("https://emulator:4566"); err != nil || e.ssm != "https://emulator:4566" || e.s3 != "https://emulator:4566" {
+		t.Errorf("--endpoint-url should apply to both services; got %+v, %v", e, err)
+	}
+	if _, err := loadEndpoints("localhost:4566"); err == nil {
+		t.Error("accepted an endpoint without a scheme")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// Labels attached by promote. A promotion is labelled with the branch and
+// version it came from, and an undo with the version it undid.
+const (
+	promotedLabelPrefix = "from-"
+	undoLabelPrefix     = "undo-v"
+)
+
+// A label that fails with a transient error is tried labelAttempts times in
+// all, waiting labelRetryDelay after the first failure and twice as long
+// after each one after that.
+const labelAttempts = 4
+
+var labelRetryDelay = time.Second
+
+// unlabelledError reports a version that promote wrote but couldn't label.
+// The write stands, so the error says how to undo it.
+type unlabelledError struct {
+	name    string
+	version int64
+	label   string
+	undo    string
+	err     error
+}
+
+func (e unlabelledError) Error() string {
+	msg := fmt.Sprintf("wrote %s version %d, but couldn't label it %s: %v", e.name, e.version, e.label, e.err)
+	if e.undo != "" {
+		msg += "; to undo it, run " + e.undo
+	}
+	return msg
+}
+
+func (e unlabelledError) Unwrap() error {
+	return e.err
+}
+
+var labelUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// promote copies a build pointer from one branch to another below a common
+// path, as a new version of the destination labelled with the source branch
+// and version. With --undo it instead restores the version the destination
+// held before its latest promotion, again as a new version. --version names
+// the version to undo, which lets a promotion that couldn't be labelled be
+// undone too.
+func promote(ctx context.Context, args []string) error {
+	const promoteUsage = "param promote [--region <region>] <path> --from <branch> --to <branch>\n" +
+		"       param promote [--region <region>] --undo [--version <n>] <path> --to <branch>"
+
+	fs := newFlagSet("promote")
+	from := fs.String("from", "", "branch to promote from")
+	to := fs.String("to", "", "branch to promote to")
+	undo := fs.Bool("undo", false, "restore the version before the latest promotion to --to")
+	undoVersion := fs.Int64("version", 0, "with --undo, the version to undo, which must be the latest; it needn't be labelled by promote")
+	region := fs.String("region", "", "AWS region")
+	positional, err := parseInterspersed(fs, args)
+	valid := err == nil && len(positional) == 1 && *to != "" && *from != *to && *undoVersion >= 0
+	if *undo {
+		valid = valid && *from == ""
+	} else {
+		valid = valid && *from != "" && *undoVersion == 0
+	}
+	if !valid {
+		return usageError(promoteUsage)
+	}
+	base := strings.TrimSuffix(positional[0], "/")
+	dst := base + "/" + *to
+
+	ssmSvc, err := newSSMClient(ctx, *region)
+	if err != nil {
+		return err
+	}
+	if *undo {
+		return undoPromotion(ctx, ssmSvc, dst, *undoVersion)
+	}
+
+	src := base + "/" + *from
+	p, err := getParameter(ctx, ssmSvc, src, true)
+	if err != nil {
+		return err
+	}
+	metas, err := describeSources(ctx, ssmSvc, nameFilter(src))
+	if err != nil {
+		return err
+	}
+
+	version, err := copyParam(ctx, ssmSvc, describedParam(src, aws.ToString(p.Value), p.Type, metas[src]), dst, true, -1)
+	if err != nil || version == 0 {
+		return err
+	}
+	label := promotedLabelPrefix + labelUnsafe.ReplaceAllString(*from, "-") + "-v" + strconv.FormatInt(p.Version, 10)
+	if err := labelVersion(ctx, ssmSvc, dst, version, label); err != nil {
+		undoCmd := fmt.Sprintf("param promote --undo --version %d %s --to %s", version, base, *to)
+		return unlabelledError{name: dst, version: version, label: label, undo: undoCmd, err: err}
+	}
+	return nil
+}
+
+// undoPromotion writes the value dst held before its latest version back as
+// a new version. If version is 0, the latest version must have been written
+// by promote; otherwise it must be the latest version, however it was
+// written.
+func undoPromotion(ctx context.Context, ssmSvc *ssm.Client, dst string, version int64) error {
+	var versions []types.ParameterHistory
+	paginator := ssm.NewGetParameterHistoryPaginator(ssmSvc, &ssm.GetParameterHistoryInput{
+		Name:           aws.String(dst),
+		WithDecryption: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("getting parameter history: %w", err)
+		}
+		versions = append(versions, page.Parameters...)
+	}
+	if len(versions) < 2 {
+		return fmt.Errorf("%s has no earlier version to restore", dst)
+	}
+
+	latest, prior := versions[len(versions)-1], versions[len(versions)-2]
+	if version != 0 && version != latest.Version {
+		return fmt.Errorf("version %d of %s is not the latest; version %d is, see param history", version, dst, latest.Version)
+	}
+	promoted := version != 0
+	for _, label := range latest.Labels {
+		promoted = promoted || strings.HasPrefix(label, promotedLabelPrefix)
+	}
+	if !promoted {
+		return fmt.Errorf("version %d of %s was not written by param promote; see param history, and pass --version %d to undo it anyway", latest.Version, dst, latest.Version)
+	}
+
+	metas, err := describeSources(ctx, ssmSvc, nameFilter(dst))
+	if err != nil {
+		return err
+	}
+	written, err := copyParam(ctx, ssmSvc, describedParam(dst+":"+strconv.FormatInt(prior.Version, 10), aws.ToString(prior.Value), prior.Type, metas[dst]), dst, false, latest.Version)
+	if err != nil {
+		return err
+	}
+	label := undoLabelPrefix + strconv.FormatInt(latest.Version, 10)
+	if err := labelVersion(ctx, ssmSvc, dst, written, label); err != nil {
+		return unlabelledError{name: dst, version: written, label: label, err: err}
+	}
+	return nil
+}
+
+// labelVersion attaches label to a version of name, retrying transient
+// failures. It carries on even if ctx has been canceled, as the version it
+// labels has already been written.
+func labelVersion(ctx context.Context, ssmSvc *ssm.Client, name string, version int64, label string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readTimeout)
+	defer cancel()
+
+	var out *ssm.LabelParameterVersionOutput
+	var err error
+	delay := labelRetryDelay
+	for attempt := 1; ; attempt++ {
+		out, err = ssmSvc.LabelParameterVersion(ctx, &ssm.LabelParameterVersionInput{
+			Name:             aws.String(name),
+			ParameterVersion: aws.Int64(version),
+			Labels:           []string{label},
+		})
+		if err == nil || attempt == labelAttempts || classify(err) != classTransient {
+			break
+		}
+		log.Printf("warning: labelling %s version %d: %v; retrying in %s", name, version, err, delay)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("labelling %s version %d: %w", name, version, err)
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("labelling %s version %d: %w", name, version, err)
+	}
+	if len(out.InvalidLabels) > 0 {
+		return fmt.Errorf("labelling %s version %d: invalid label %q", name, version, out.InvalidLabels[0])
+	}
+	log.Printf("labelled %s version %d %s", name, version, label)
+	return nil
+}
+
+func nameFilter(name string) types.ParameterStringFilter {
+	return types.ParameterStringFilter{
+		Key:    aws.String("Name"),
+		Option: aws.String("Equals"),
+		Values: []string{name},
+	}
+}
+
+// parseInterspersed parses flags that may come before or after positional
+// arguments, as in `param promote <path> --from a --to b`, and returns the
+// positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"jolli.ai/param/fakeaws"
+)
+
+func TestPromoteAndUndo(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/build/jolli-web/main", "String", "s3://builds/web-1.tgz")
+	srv.SetParameter("/build/jolli-web/main", "String", "s3://builds/web-2.tgz")
+	srv.SetParameter("/build/jolli-web/prod", "String", "s3://builds/web-0.tgz")
+
+	if _, err := runCommand(t, promote, "/build/jolli-web", "--from", "main", "--to", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	value, version, _ := srv.Parameter("/build/jolli-web/prod")
+	if value != "s3://builds/web-2.tgz" || version != 2 {
+		t.Errorf("promoted %q as version %d", value, version)
+	}
+	if labels := srv.Labels("/build/jolli-web/prod", 2); !slices.Equal(labels, []string{"from-main-v2"}) {
+		t.Errorf("promotion labelled %v", labels)
+	}
+
+	if _, err := runCommand(t, promote, "--undo", "/build/jolli-web", "--to", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	value, version, _ = srv.Parameter("/build/jolli-web/prod")
+	if value != "s3://builds/web-0.tgz" || version != 3 {
+		t.Errorf("undo restored %q as version %d", value, version)
+	}
+	if labels := srv.Labels("/build/jolli-web/prod", 3); !slices.Equal(labels, []string{"undo-v2"}) {
+		t.Errorf("undo labelled %v", labels)
+	}
+
+	// The latest version is an undo, not a promotion, so there is nothing
+	// more to undo.
+	if _, err := runCommand(t, promote, "--undo", "/build/jolli-web", "--to", "prod"); err == nil {
+		t.Error("undid a version that promote didn't write")
+	}
+}
+
+func TestPromoteLabelFailure(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.SetParameter("/build/jolli-web/main", "String", "s3://builds/web-1.tgz")
+	srv.SetParameter("/build/jolli-web/prod", "String", "s3://builds/web-0.tgz")
+	labelRetryDelay = time.Millisecond
+	t.Cleanup(func() { labelRetryDelay = time.Second })
+
+	// A label that is throttled once is retried.
+	throttled := false
+	srv.FailWith(func(op string) *fakeaws.Error {
+		if op != "LabelParameterVersion" || throttled {
+			return nil
+		}
+		throttled = true
+		return &fakeaws.Error{Status: http.StatusBadRequest, Code: "ThrottlingException", Message: "slow down"}
+	})
+	if _, err := runCommand(t, promote, "/build/jolli-web", "--from", "main", "--to", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if labels := srv.Labels("/build/jolli-web/prod", 2); !slices.Equal(labels, []string{"from-main-v1"}) {
+		t.Errorf("promotion labelled %v", labels)
+	}
+
+	// A label that can't be attached leaves the written version named, and
+	// undo can target it explicitly.
+	srv.SetParameter("/build/jolli-web/main", "String", "s3://builds/web-2.tgz")
+	srv.FailWith(func(op string) *fakeaws.Error {
+		if op != "LabelParameterVersion" {
+			return nil
+		}
+		return &fakeaws.Error{Status: http.StatusBadRequest, Code: "AccessDeniedException", Message: "no"}
+	})
+	_, err := runCommand(t, promote, "/build/jolli-web", "--from", "main", "--to", "prod")
+	var unlabelled unlabelledError
+	if !errors.As(err, &unlabelled) || unlabelled.version != 3 || !strings.Contains(err.Error(), "--undo --version 3") {
+		t.Fatalf("got %v, want version 3 reported as unlabelled", err)
+	}
+	srv.FailWith(nil)
+
+	if _, err := runCommand(t, promote, "--undo", "/build/jolli-web", "--to", "prod"); err == nil {
+		t.Error("undid an unlabelled version without --version")
+	}
+	if _, err := runCommand(t, promote, "--undo", "--version", "2", "/build/jolli-web", "--to", "prod"); err == nil {
+		t.Error("undid a version that isn't the latest")
+	}
+	if _, err := runCommand(t, promote, "--undo", "--version", "3", "/build/jolli-web", "--to", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if value, version, _ := srv.Parameter("/build/jolli-web/prod"); value != "s3://builds/web-1.tgz" || version != 4 {
+		t.Errorf("undo restored %q as version %d", value, version)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// stringList collects a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// packageDescriptor is the part of a dist directory's package.json that
+// describes the build tarball made from it.
+type packageDescriptor struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Package is the tarball's file name, relative to the working directory.
+	Package string `json:"package"`
+}
+
+// overwriteError reports a build whose S3 key already holds something else.
+type overwriteError struct {
+	url      string
+	existing string
+	local    string
+}
+
+func (e overwriteError) Error() string {
+	return fmt.Sprintf("refusing to overwrite %s: it has sha256 %s but the local package has %s", e.url, e.existing, e.local)
+}
+
+// publish uploads the build tarball described by each dist directory's
+// package.json to s3://<bucket>/<name>/<version>/<package> with a .sha256
+// sidecar, and points /build/<name>/<branch> at it. A tarball that is already
+// in S3 is left alone if its content matches, and is an error otherwise.
+func publish(ctx context.Context, args []string) error {
+	const publishUsage = "param publish --bucket <bucket> --dist <dir>... [--branch <branch>] [--region <region>]"
+
+	var dists stringList
+	fs := newFlagSet("publish")
+	bucket := fs.String("bucket", "", "S3 bucket to upload builds to")
+	fs.Var(&dists, "dist", "directory holding a package.json that describes a build; may be repeated")
+	branch := fs.String("branch", "", "branch to publish the builds for; defaults to the current git branch")
+	region := fs.String("region", "", "AWS region")
+	if err := fs.Parse(args); err != nil || *bucket == "" || len(dists) == 0 || fs.NArg() != 0 {
+		return usageError(publishUsage)
+	}
+
+	if *branch == "" {
+		out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+		if err != nil {
+			return fmt.Errorf("finding the current git branch: %w", err)
+		}
+		*branch = strings.TrimSpace(string(out))
+	}
+
+	descriptors := make([]packageDescriptor, len(dists))
+	for i, dist := range dists {
+		d, err := loadPackageDescriptor(dist)
+		if err != nil {
+			return err
+		}
+		descriptors[i] = d
+	}
+
+	cfg, err := loadAWSConfig(ctx, *region)
+	if err != nil {
+		return err
+	}
+	s3Svc, ssmSvc := newS3(cfg), newSSM(cfg)
+
+	for _, d := range descriptors {
+		url, err := uploadPackage(ctx, s3Svc, *bucket, d)
+		if err != nil {
+			return err
+		}
+
+		name := "/build/" + d.Name + "/" + *branch
+		unlock, err := lockParam(ctx, ssmSvc, name)
+		if err != nil {
+			return err
+		}
+		_, err = ssmSvc.PutParameter(ctx, &ssm.PutParameterInput{
+			Name:      aws.String(name),
+			Value:     aws.String(url),
+			Type:      types.ParameterTypeString,
+			Overwrite: aws.Bool(true),
+		})
+		unlock()
+		if err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+		log.Printf("%s = %s", name, url)
+	}
+	return nil
+}
+
+func loadPackageDescriptor(dist string) (packageDescriptor, error) {
+	var d packageDescriptor
+	file := filepath.Join(dist, "package.json")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return d, err
+	}
+	if err := json.Unmarshal(data, &d); err != nil {
+		return d, fmt.Errorf("%s: %w", file, err)
+	}
+	if d.Name == "" || d.Version == "" || d.Package == "" {
+		return d, fmt.Errorf("%s needs a name, version and package", file)
+	}
+	if path.Base(d.Package) != d.Package || strings.ContainsAny(d.Name+d.Version, "/") {
+		return d, fmt.Errorf("%s: name, version and package must not contain /", file)
+	}
+	return d, nil
+}
+
+// uploadPackage uploads a package tarball and its .sha256 sidecar unless S3
+// already holds them, and returns the tarball's s3:// URL.
+func uploadPackage(ctx context.Context, s3Svc *s3.Client, bucket string, d packageDescriptor) (string, error) {
+	key := d.Name + "/" + d.Version + "/" + d.Package
+	url := "s3://" + bucket + "/" + key
+
+	sum, err := fileSHA256(d.Package)
+	if err != nil {
+		return "", err
+	}
+
+	existing, err := existingChecksum(ctx, s3Svc, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if existing != "" && existing != sum {
+		return "", overwriteError{url: url, existing: existing, local: sum}
+	}
+
+	if existing == "" {
+		if err := putFile(ctx, s3Svc, bucket, key, d.Package); err != nil {
+			return "", fmt.Errorf("uploading %s: %w", url, err)
+		}
+		log.Printf("uploaded %s", url)
+	} else {
+		log.Printf("%s is already published", url)
+	}
+
+	published, err := publishedChecksum(ctx, s3Svc, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if published != sum {
+		if _, err := s3Svc.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key + checksumSuffix),
+			Body:   strings.NewReader(sum + "  " + d.Package + "\n"),
+		}); err != nil {
+			return "", fmt.Errorf("uploading %s%s: %w", url, checksumSuffix, err)
+		}
+	}
+	return url, nil
+}
+
+// existingChecksum returns the SHA-256 of the object at key, or "" if there
+// is no such object. The published sidecar is trusted if there is one;
+// otherwise the object is downloaded and hashed.
+func existingChecksum(ctx context.Context, s3Svc *s3.Client, bucket, key string) (string, error) {
+	_, err := s3Svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("checking s3://%s/%s: %w", bucket, key, err)
+	}
+
+	sum, err := publishedChecksum(ctx, s3Svc, bucket, key)
+	if err != nil || sum != "" {
+		return sum, err
+	}
+
+	obj, err := s3Svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("getting s3://%s/%s: %w", bucket, key, err)
+	}
+	defer obj.Body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, obj.Body); err != nil {
+		return "", fmt.Errorf("reading s3://%s/%s: %w", bucket, key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func putFile(ctx context.Context, s3Svc *s3.Client, bucket, key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = s3Svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	return err
+}
+
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPublishAndDownload(t *testing.T) {
+	srv := newFakeAWS(t)
+	srv.CreateBucket("builds")
+
+	dist := t.TempDir()
+	t.Chdir(dist)
+	os.WriteFile("web-1.0.0.tgz", []byte("tarball"), 0644)
+	os.WriteFile("package.json", []byte(`{"name":"jolli-web","version":"1.0.0","package":"web-1.0.0.tgz"}`), 0644)
+
+	if _, err := runCommand(t, publish, "--bucket", "builds", "--dist", dist, "--branch", "main"); err != nil {
+		t.Fatal(err)
+	}
+	url, _, _ := srv.Parameter("/build/jolli-web/main")
+	if url != "s3://builds/jolli-web/1.0.0/web-1.0.0.tgz" {
+		t.Fatalf("/build/jolli-web/main = %q", url)
+	}
+	if sidecar, _ := srv.Object("builds", "jolli-web/1.0.0/web-1.0.0.tgz.sha256"); len(sidecar) < 64 {
+		t.Errorf("sidecar holds %q", sidecar)
+	}
+
+	cfg, err := loadAWSConfig(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3Svc := newS3(cfg)
+	file, err := download(context.Background(), s3Svc, url, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "tarball" || filepath.Base(file) != "web-1.0.0.tgz" {
+		t.Errorf("downloaded %q to %s", data, file)
+	}
+
+	// A download that doesn't match its sidecar is rejected.
+	srv.PutObject("builds", "jolli-web/1.0.0/web-1.0.0.tgz", []byte("tampered"))
+	var mismatch checksumError
+	if _, err := download(context.Background(), s3Svc, url, t.TempDir()); !errors.As(err, &mismatch) {
+		t.Errorf("got %v, want a checksum mismatch", err)
+	}
+
+	// Publishing different content under the same version is refused.
+	os.WriteFile("web-1.0.0.tgz", []byte("rebuilt"), 0644)
+	var overwrite overwriteError
+	if _, err := runCommand(t, publish, "--bucket", "builds", "--dist", dist, "--branch", "main"); !errors.As(err, &overwrite) {
+		t.Errorf("got %v, want an overwrite error", err)
+	}
+}
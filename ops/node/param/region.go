@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// imdsTimeout bounds the instance metadata lookup of the region, which off
+// EC2 would otherwise wait out the IMDS client's retries.
+const imdsTimeout = 5 * time.Second
+
+// imdsRegion returns the region of the EC2 instance param runs on, from the
+// instance identity document. The IMDS client fetches a session token first,
+// so this works on instances that require IMDSv2.
+func imdsRegion(ctx context.Context, cfg aws.Config) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+	out, err := imds.NewFromConfig(cfg).GetRegion(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("no region: pass --region or set AWS_REGION, or run on EC2 (instance metadata: %w)", err)
+	}
+	return out.Region, nil
+}
+
+// region prints the region param resolves: --region, then AWS_REGION or the
+// shared config, then the instance's region from its metadata.
+func region(ctx context.Context, args []string) error {
+	fs := newFlagSet("region")
+	flagRegion := fs.String("region", "", "AWS region")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return usageError("param region [--region <region>]")
+	}
+	cfg, err := loadAWSConfig(ctx, *flagRegion)
+	if err != nil {
+		return err
+	}
+	fmt.Println(cfg.Region)
+	return nil
+}
+
+// fallbackFlag registers --fallback-regions on a command's flag set. It
+// defaults to PARAM_FALLBACK_REGIONS.
+func fallbackFlag(fs *flag.FlagSet) *string {
+	return fs.String("fallback-regions", os.Getenv("PARAM_FALLBACK_REGIONS"), "comma-separated regions to read replicated parameters from when the primary region is unavailable")
+}
+
+// splitRegions splits a comma-separated region list.
+func splitRegions(list string) []string {
+	var regions []string
+	for _, r := range strings.Split(list, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// newSSMClients returns an SSM client for the primary region followed by one
+// for each fallback region, in order.
+func newSSMClients(ctx context.Context, region, fallbacks string) ([]*ssm.Client, error) {
+	cfg, err := loadAWSConfig(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	clients := []*ssm.Client{newSSM(cfg)}
+	for _, r := range splitRegions(fallbacks) {
+		if r != cfg.Region {
+			regional := cfg.Copy()
+			regional.Region = r
+			clients = append(clients, newSSM(regional))
+		}
+	}
+	return clients, nil
+}
+
+// readWithFallback reads from the first client's region and, if that fails
+// with a transient error, from each of the others in turn. A deadline on ctx
+// is shared between the regions still to try, so a primary region that hangs
+// leaves time for the fallbacks. If every region fails, the primary region's
+// error is returned.
+func readWithFallback[T any](ctx context.Context, clients []*ssm.Client, read func(context.Context, *ssm.Client) (T, error)) (T, error) {
+	var value T
+	var primaryErr error
+	for i, c := range clients {
+		regionCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok && i < len(clients)-1 {
+			regionCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(clients)-i))
+		}
+		v, err := read(regionCtx, c)
+		cancel()
+		if err == nil {
+			if i > 0 {
+				log.Printf("warning: read from fallback region %s: %v", c.Options().Region, primaryErr)
+			}
+			return v, nil
+		}
+
+		if i == 0 {
+			primaryErr = err
+			if classify(err) != classTransient {
+				return value, err
+			}
+		} else if !errors.Is(err, context.Canceled) {
+			log.Printf("warning: fallback region %s: %v", c.Options().Region, err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return value, primaryErr
+}
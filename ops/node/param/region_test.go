@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"jolli.ai/param/fakeaws"
+)
+
+func TestReadWithFallback(t *testing.T) {
+	primary := newFakeAWS(t)
+	primary.SetParameter("/build/jolli-web/main", "String", "s3://builds/web-1.tgz")
+	replica := fakeaws.New()
+	t.Cleanup(replica.Close)
+	replica.SetParameter("/build/jolli-web/main", "String", "s3://builds/web-1.tgz")
+
+	cfg, err := loadAWSConfig(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	west := newSSM(cfg)
+	east := ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+		o.Region = "us-east-2"
+		o.BaseEndpoint = aws.String(replica.URL)
+	})
+	clients := []*ssm.Client{west, east}
+	read := func(ctx context.Context, c *ssm.Client) (*string, error) {
+		p, err := getParameter(ctx, c, "/build/jolli-web/main", false)
+		if err != nil {
+			return nil, err
+		}
+		return p.Value, nil
+	}
+
+	// The primary region's outage is retried until its share of the
+	// deadline runs out, leaving the rest for the replica.
+	primary.FailWith(func(string) *fakeaws.Error {
+		return &fakeaws.Error{Status: http.StatusServiceUnavailable, Code: "ServiceUnavailable", Message: "down"}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if v, err := readWithFallback(ctx, clients, read); err != nil || *v != "s3://builds/web-1.tgz" {
+		t.Errorf("got %v, %v; want the replica's value", v, err)
+	}
+
+	// Only transient errors fall back: a missing parameter is missing.
+	primary.FailWith(nil)
+	replica.SetParameter("/build/jolli-web/prod", "String", "s3://builds/web-0.tgz")
+	read = func(ctx context.Context, c *ssm.Client) (*string, error) {
+		p, err := getParameter(ctx, c, "/build/jolli-web/prod", false)
+		if err != nil {
+			return nil, err
+		}
+		return p.Value, nil
+	}
+	if _, err := readWithFallback(context.Background(), clients, read); classify(err) != classNotFound {
+		t.Errorf("got %v; want the primary region's not found", err)
+	}
+}
+
+func TestNewSSMClients(t *testing.T) {
+	newFakeAWS(t)
+	clients, err := newSSMClients(context.Background(), "", " us-east-2, us-west-2,,eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var regions []string
+	for _, c := range clients {
+		regions = append(regions, c.Options().Region)
+	}
+	if len(regions) != 3 || regions[0] != "us-west-2" || regions[1] != "us-east-2" || regions[2] != "eu-west-1" {
+		t.Errorf("got regions %v", regions)
+	}
+}
+
+func TestRegionWithoutMetadata(t *testing.T) {
+	newFakeAWS(t)
+	endpoints = awsEndpoints{}
+	t.Setenv("AWS_REGION", "")
+	if _, err := runCommand(t, region); err == nil {
+		t.Error("resolved a region with no flag, AWS_REGION or instance metadata")
+	}
+	if got, err := runCommand(t, region, "--region", "eu-west-1"); err != nil || got != "eu-west-1\n" {
+		t.Errorf("region --region eu-west-1 = %q, %v", got, err)
+	}
+}
+
+func TestSyncWaitsForRegion(t *testing.T) {
+	newFakeAWS(t)
+	endpoints = awsEndpoints{}
+	t.Setenv("AWS_REGION", "")
+
+	// With no region to be found, the daemon keeps retrying instead of
+	// exiting, until it is stopped.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(1500*time.Millisecond, cancel)
+	start := time.Now()
+	if err := syncServers(ctx, []string{"--servers", t.TempDir()}); err != nil {
+		t.Errorf("got %v, want a clean stop", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("sync gave up after %s", elapsed)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// rollback points a server's current symlink back at the good install it ran
+// before the last deploy and restarts it. The deployed URL is left alone, so
+// the sync daemon won't redeploy the build that was rolled back until BUILD
+// changes.
+func rollback(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return usageError("param rollback <server-dir>")
+	}
+	dir := args[0]
+
+	state, err := loadState(dir)
+	if err != nil {
+		return fmt.Errorf("reading deploy state: %w", err)
+	}
+	if state.Previous == "" {
+		return fmt.Errorf("%s has no previous install to roll back to", dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, state.Previous)); err != nil {
+		return fmt.Errorf("previous install: %w", err)
+	}
+
+	from, err := swapCurrent(dir, state.Previous)
+	if err != nil {
+		return err
+	}
+	state.Current, state.Previous = state.Previous, from
+	state.Good = state.Current
+	if err := saveState(dir, state); err != nil {
+		return fmt.Errorf("saving deploy state: %w", err)
+	}
+	log.Printf("%s: rolled back from %s to %s", dir, from, state.Current)
+
+	return restartServer(dir)
+}
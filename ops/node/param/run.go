@@ -0,0 +1,628 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"jolli.ai/param/serverconfig"
+)
+
+const (
+	// runnerPIDFile holds the PID of the `param run` supervising a server. The
+	// supervisor keeps it locked for as long as it runs.
+	runnerPIDFile = "run.pid"
+	// appPIDFile holds the PID of the app process, which leads its own
+	// process group.
+	appPIDFile = "app.pid"
+	// logFile collects the output of the app and its supervisor.
+	logFile = "node.log"
+	// runnerLogFile takes what a supervisor started by startRunner writes
+	// to stdout and stderr itself, such as a panic or an error from before
+	// node.log is open. It is never rotated, so those are not written to a
+	// node.log that rotation has since removed.
+	runnerLogFile = "run.log"
+
+	// waitDelay bounds how long to wait for the app's output to be drained
+	// once it has exited, in case something it started still holds it open.
+	waitDelay = 5 * time.Second
+
+	defaultStopTimeout = 10 * time.Second
+)
+
+// An app that crashes is restarted after a delay that doubles from
+// minRestartDelay up to maxRestartDelay, and resets once the app has stayed
+// up for stableAfter.
+var (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+	stableAfter     = time.Minute
+)
+
+// The app is started with nvm's node, the same way start.sh did.
+const (
+	prepareScript = `source "$NVM_DIR/nvm.sh" && nvm install && npm install`
+	startScript   = `source "$NVM_DIR/nvm.sh" && nvm use >/dev/null && exec npm run start`
+)
+
+// runner supervises the app in one server directory.
+type runner struct {
+	dir         string
+	stopTimeout time.Duration
+	log         *rotatingLog
+	// bg is set if the server restarts its app blue/green.
+	bg *blueGreen
+}
+
+// app is a running app process.
+type app struct {
+	cmd *exec.Cmd
+	// port is the port the app was told to listen on, or 0.
+	port int
+	// done is closed once the app has exited, with err set to why.
+	done chan struct{}
+	err  error
+}
+
+// run supervises a server's app: it starts the app in its own process group,
+// restarts it with backoff if it exits, restarts it on SIGHUP, and stops it
+// on SIGTERM or SIGINT, first with SIGTERM and then with SIGKILL if it
+// hasn't exited within the stop timeout. The app's output and the
+// supervisor's own messages are written, timestamped, to node.log, which is
+// rotated by size and age.
+func run(ctx context.Context, args []string) error {
+	const runUsage = "param run [--stop-timeout <duration>] [--log-max-size <MB>] [--log-max-age <duration>] [--log-keep <n>] <server-dir>"
+
+	fs := newFlagSet("run")
+	stopTimeout := fs.Duration("stop-timeout", defaultStopTimeout, "time to wait after SIGTERM before sending SIGKILL")
+	logMaxSize := fs.Int("log-max-size", defaultLogMaxSizeMB, "size in MB at which node.log is rotated")
+	logMaxAge := fs.Duration("log-max-age", defaultLogMaxAge, "age at which node.log is rotated")
+	logKeep := fs.Int("log-keep", defaultLogKeep, "number of rotated logs to keep")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return usageError(runUsage)
+	}
+	dir := fs.Arg(0)
+
+	cfg, err := serverconfig.Load(dir)
+	if err != nil {
+		return err
+	}
+	if *stopTimeout, err = cfg.Duration("STOP_TIMEOUT", *stopTimeout); err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if *logMaxSize, err = cfg.Int("LOG_MAX_SIZE_MB", *logMaxSize); err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if *logMaxAge, err = cfg.Duration("LOG_MAX_AGE", *logMaxAge); err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if *logKeep, err = cfg.Int("LOG_KEEP", *logKeep); err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+
+	// Signals are caught before the lock is taken, so a restart sent as
+	// soon as the lock names this process doesn't kill it.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	lock, err := lockRunner(dir)
+	if err != nil {
+		return err
+	}
+	defer unlockRunner(lock)
+
+	out, err := openRotatingLog(filepath.Join(dir, logFile), int64(*logMaxSize)<<20, *logMaxAge, *logKeep)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	log.SetFlags(0)
+	log.SetOutput(out.stream("param"))
+
+	bg, err := loadBlueGreen(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if bg != nil {
+		defer bg.sw.Close()
+	}
+
+	r := &runner{dir: dir, stopTimeout: *stopTimeout, log: out, bg: bg}
+	return r.supervise(ctx, signals)
+}
+
+// supervise runs the app until told to stop by one of signals. A SIGHUP
+// that arrived before the app first starts is dropped, as the app it starts
+// already runs the current install.
+func (r *runner) supervise(ctx context.Context, signals chan os.Signal) error {
+	select {
+	case sig := <-signals:
+		if sig != syscall.SIGHUP {
+			return nil
+		}
+	default:
+	}
+
+	delay := minRestartDelay
+	for {
+		started := time.Now()
+		a, err := r.start(ctx, r.firstPort(), signals)
+		if err != nil {
+			log.Printf("%s: starting app: %v", r.dir, err)
+		} else if err := r.serve(a); err != nil {
+			r.stop(a)
+			log.Printf("%s: starting app: %v", r.dir, err)
+		} else {
+			restart, err := r.wait(ctx, a, signals)
+			if errors.Is(err, errStopped) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if restart {
+				log.Printf("%s: restarting app", r.dir)
+				delay = minRestartDelay
+				continue
+			}
+			if time.Since(started) >= stableAfter {
+				delay = minRestartDelay
+			}
+		}
+
+		log.Printf("%s: restarting app in %s", r.dir, delay)
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				return nil
+			}
+		case <-ctx.Done():
+			return stopped(ctx)
+		case <-time.After(delay):
+			delay = min(delay*2, maxRestartDelay)
+		}
+	}
+}
+
+// errStopped reports that the supervisor was told to stop.
+var errStopped = errors.New("stopped")
+
+// wait watches a running app until it exits. On SIGHUP a blue/green server's
+// app is replaced in place and watching carries on; any other app is stopped
+// and wait reports that it should be restarted right away. On SIGTERM or
+// SIGINT the app is stopped and wait returns errStopped.
+func (r *runner) wait(ctx context.Context, a *app, signals chan os.Signal) (restart bool, err error) {
+	for {
+		select {
+		case <-a.done:
+			r.log.marker("app exited: %v", exitStatus(a.err))
+			return false, nil
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				r.stop(a)
+				return false, errStopped
+			}
+			if r.bg == nil {
+				r.stop(a)
+				return true, nil
+			}
+			log.Printf("%s: replacing app", r.dir)
+			a = r.replace(ctx, a, signals)
+		case <-ctx.Done():
+			r.stop(a)
+			if err := stopped(ctx); err != nil {
+				return false, err
+			}
+			return false, errStopped
+		}
+	}
+}
+
+// firstPort is the port to start an app on when no other app is running.
+func (r *runner) firstPort() int {
+	if r.bg == nil {
+		return 0
+	}
+	return r.bg.ports[0]
+}
+
+// serve makes a freshly started app the one that receives traffic.
+func (r *runner) serve(a *app) error {
+	if r.bg != nil {
+		if err := r.bg.sw.route(a.port); err != nil {
+			return err
+		}
+	}
+	r.activate(a)
+	return nil
+}
+
+// activate records a as the server's app in app.pid.
+func (r *runner) activate(a *app) {
+	pid := a.cmd.Process.Pid
+	if err := writeFileAtomic(filepath.Join(r.dir, appPIDFile), []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+		log.Printf("%s: writing %s: %v", r.dir, appPIDFile, err)
+	}
+}
+
+// start copies the server's env file into the current install and starts
+// the app there. By default the app's node version and dependencies are
+// installed first and it is started with `npm run start`. A server whose
+// .config sets START runs that command instead, with the env file's
+// variables exported to it, and WORKDIR names a subdirectory of the install
+// to run it in. If port isn't 0 the app is told to listen on it through PORT.
+// A signal arriving while dependencies are installed stops the install and
+// is left for the caller to handle.
+func (r *runner) start(ctx context.Context, port int, signals chan os.Signal) (*app, error) {
+	cfg, err := serverconfig.Load(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	current := filepath.Join(r.dir, currentLink)
+
+	install, err := os.Readlink(current)
+	if err != nil {
+		return nil, err
+	}
+	workdir := current
+	if sub := cfg.Get("WORKDIR"); sub != "" {
+		if !filepath.IsLocal(sub) {
+			return nil, fmt.Errorf("WORKDIR must be a path inside the install, got %q", sub)
+		}
+		workdir = filepath.Join(current, sub)
+	}
+	r.log.marker("preparing %s", install)
+
+	script := startScript
+	if start := cfg.Get("START"); start != "" {
+		script = "exec " + start
+	} else if err := r.prepare(ctx, workdir, signals); err != nil {
+		return nil, fmt.Errorf("installing dependencies: %w", err)
+	}
+
+	var env []string
+	if cfg.Env != "" {
+		data, err := os.ReadFile(cfg.Env)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(filepath.Join(workdir, filepath.Base(cfg.Env)), data, 0600); err != nil {
+			return nil, err
+		}
+		if script != startScript {
+			env = exportedEnv(data)
+		}
+	}
+	if port != 0 {
+		env = append(env, "PORT="+strconv.Itoa(port))
+	}
+
+	// The app is not tied to ctx: it is only ever stopped through stop, so
+	// it gets the chance to shut down gracefully.
+	a, err := r.launch(workdir, script, env...)
+	if err != nil {
+		return nil, err
+	}
+	a.port = port
+	pid := a.cmd.Process.Pid
+	if port != 0 {
+		r.log.marker("started %s on port %d with pid %d", install, port, pid)
+	} else {
+		r.log.marker("started %s with pid %d", install, pid)
+	}
+	return a, nil
+}
+
+// prepare installs the node version and dependencies of the install in dir.
+// The install runs in its own process group, so that stopping it on a signal
+// or once ctx is done also stops the npm and node processes it started.
+func (r *runner) prepare(ctx context.Context, dir string, signals chan os.Signal) error {
+	p, err := r.launch(dir, prepareScript)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-p.done:
+		return p.err
+	case sig := <-signals:
+		r.stop(p)
+		signals <- sig
+		return fmt.Errorf("interrupted by %v", sig)
+	case <-ctx.Done():
+		r.stop(p)
+		return ctx.Err()
+	}
+}
+
+// launch starts a bash script in dir in its own process group, so that stop
+// can signal everything it starts. The app.pid file is removed when the
+// process it names exits.
+func (r *runner) launch(dir, script string, env ...string) (*app, error) {
+	cmd, flush := r.command(dir, script, env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+
+	a := &app{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		a.err = cmd.Wait()
+		flush()
+		if appPID(r.dir) == pid {
+			os.Remove(filepath.Join(r.dir, appPIDFile))
+		}
+		close(a.done)
+	}()
+	return a, nil
+}
+
+// exportedEnv turns the contents of a dotenv file into environment entries.
+func exportedEnv(data []byte) []string {
+	vars := parseDotenv(data)
+	env := make([]string, 0, len(vars))
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		env = append(env, name+"="+vars[name])
+	}
+	return env
+}
+
+// command prepares a bash script to run in dir with its output captured in
+// the log. The returned function logs any trailing partial line once the
+// command has finished.
+func (r *runner) command(dir, script string, env ...string) (*exec.Cmd, func()) {
+	stdout, stderr := r.log.stream("stdout"), r.log.stream("stderr")
+	cmd := exec.Command("bash", "-c", script)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = waitDelay
+	if os.Getenv("NVM_DIR") == "" {
+		home, _ := os.UserHomeDir()
+		env = append(env, "NVM_DIR="+filepath.Join(home, ".nvm"))
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd, func() {
+		stdout.Flush()
+		stderr.Flush()
+	}
+}
+
+// exitStatus describes how a process exited for the log.
+func exitStatus(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// stop sends SIGTERM to the app's process group, then SIGKILL if it hasn't
+// exited within the stop timeout, and waits for it to exit.
+func (r *runner) stop(a *app) {
+	pgid := a.cmd.Process.Pid
+	select {
+	case <-a.done:
+		r.log.marker("app with pid %d already exited: %v", pgid, exitStatus(a.err))
+		return
+	default:
+	}
+	r.log.marker("stopping app with pid %d", pgid)
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		log.Printf("%s: sending SIGTERM: %v", r.dir, err)
+	}
+
+	select {
+	case <-a.done:
+		r.log.marker("app stopped: %v", exitStatus(a.err))
+		return
+	case <-time.After(r.stopTimeout):
+	}
+
+	log.Printf("%s: app did not exit within %s, sending SIGKILL", r.dir, r.stopTimeout)
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil {
+		log.Printf("%s: sending SIGKILL: %v", r.dir, err)
+	}
+	<-a.done
+	r.log.marker("app killed: %v", exitStatus(a.err))
+}
+
+// appPID returns the PID of a server's running app, or 0 if it isn't running.
+func appPID(dir string) int {
+	data, err := os.ReadFile(filepath.Join(dir, appPIDFile))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// errRunnerLocked reports that another `param run` already supervises the
+// server.
+var errRunnerLocked = errors.New("already supervised by another param run")
+
+// lockProbeWait is how long lockRunner keeps trying a lock that is held,
+// which is far longer than runnerPID holds it to probe.
+const lockProbeWait = time.Second
+
+// lockRunner takes the lock that marks a server as supervised and records
+// this process's PID in it.
+func lockRunner(dir string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, runnerPIDFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for deadline := time.Now().Add(lockProbeWait); ; {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", dir, errRunnerLocked)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := f.Truncate(0); err != nil {
+		unlockRunner(f)
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		unlockRunner(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+func unlockRunner(f *os.File) {
+	f.Truncate(0)
+	f.Close()
+}
+
+// runnerPID returns the PID of the `param run` supervising a server, or 0 if
+// there is none. It probes the lock with a shared lock that it drops at
+// once, and never writes the file, so a supervisor starting at the same
+// time isn't disturbed.
+func runnerPID(dir string) (int, error) {
+	path := filepath.Join(dir, runnerPIDFile)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		return 0, nil
+	}
+	if !errors.Is(err, syscall.EWOULDBLOCK) {
+		return 0, err
+	}
+
+	// A supervisor that has only just taken the lock may not have written
+	// its PID yet.
+	for deadline := time.Now().Add(lockProbeWait); ; {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return 0, err
+		}
+		if pid := strings.TrimSpace(string(data)); pid != "" || time.Now().After(deadline) {
+			return strconv.Atoi(pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// restart asks the supervisor of each server directory to restart its app,
+// starting a supervisor if there isn't one.
+func restart(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usageError("param restart <server-dir>...")
+	}
+
+	for _, dir := range args {
+		if err := restartServer(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restartServer sends SIGHUP to a server's supervisor, or starts a new
+// `param run` for it in the background if none is running.
+func restartServer(dir string) error {
+	pid, err := runnerPID(dir)
+	if err != nil {
+		return err
+	}
+	if pid != 0 {
+		return syscall.Kill(pid, syscall.SIGHUP)
+	}
+	return startRunner(dir)
+}
+
+// ensureRunning starts a supervisor for a server if it doesn't have one, for
+// instance after a reboot.
+func ensureRunning(dir string) error {
+	pid, err := runnerPID(dir)
+	if err != nil || pid != 0 {
+		return err
+	}
+	return startRunner(dir)
+}
+
+// externallySupervised reports whether a server's .config sets
+// SUPERVISOR=systemd, meaning a service unit runs its `param run`. param
+// then only signals that supervisor and never starts one of its own, which
+// would live in the cgroup of whatever started it and die with it.
+func externallySupervised(dir string) (bool, error) {
+	cfg, err := serverconfig.Load(dir)
+	if err != nil {
+		return false, err
+	}
+	switch supervisor := cfg.Get("SUPERVISOR"); supervisor {
+	case "", "param":
+		return false, nil
+	case "systemd":
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s: SUPERVISOR must be param or systemd, got %q", dir, supervisor)
+	}
+}
+
+// startRunner starts `param run` for a server in its own session, so it
+// outlives the process that started it, with its output going to run.log. A
+// server run by a service unit is left to it: the unit restarts its
+// supervisor, which starts current.
+func startRunner(dir string) error {
+	external, err := externallySupervised(dir)
+	if err != nil {
+		return err
+	}
+	if external {
+		log.Printf("%s: no supervisor running; leaving it to its service unit", dir)
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(filepath.Join(dir, runnerLogFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	cmd := exec.Command(self, "run", dir)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("%s: started supervisor with pid %d", dir, cmd.Process.Pid)
+	return cmd.Process.Release()
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"jolli.ai/param/serverconfig"
+)
+
+// newTestRunner returns a runner for a server directory with the given
+// .config and an empty current install. Its messages go to node.log, as
+// those of `param run` do.
+func newTestRunner(t *testing.T, config string) *runner {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, serverconfig.FileName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "installs", "web-1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swapCurrent(dir, "installs/web-1"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := openRotatingLog(filepath.Join(dir, logFile), 1<<20, time.Hour, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.SetFlags(0)
+	log.SetOutput(out.stream("param"))
+	t.Cleanup(func() {
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+		out.Close()
+	})
+	return &runner{dir: dir, stopTimeout: time.Second, log: out}
+}
+
+// testSupervisor is a runner supervising its app in the background.
+type testSupervisor struct {
+	r       *runner
+	signals chan os.Signal
+	done    chan error
+
+	once sync.Once
+	err  error
+}
+
+func startSupervisor(t *testing.T, r *runner) *testSupervisor {
+	s := &testSupervisor{r: r, signals: make(chan os.Signal, 1), done: make(chan error, 1)}
+	go func() { s.done <- r.supervise(context.Background(), s.signals) }()
+	t.Cleanup(func() { s.stop(t) })
+	return s
+}
+
+// stop sends the supervisor SIGTERM and returns what supervise returned.
+func (s *testSupervisor) stop(t *testing.T) error {
+	s.once.Do(func() {
+		s.signals <- syscall.SIGTERM
+		select {
+		case s.err = <-s.done:
+		case <-time.After(10 * time.Second):
+			t.Error("the supervisor did not stop")
+		}
+	})
+	return s.err
+}
+
+// logged returns the contents of the server's node.log.
+func (s *testSupervisor) logged() string {
+	data, _ := os.ReadFile(filepath.Join(s.r.dir, logFile))
+	return string(data)
+}
+
+// waitFor polls cond until it holds, and fails the test if it doesn't
+// within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// alive reports whether pid is a running process. A zombie, which has exited
+// but not been reaped yet by whatever it was reparented to, isn't.
+func alive(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return syscall.Kill(pid, 0) == nil
+	}
+	// The state follows the command name, which is in parentheses.
+	state := data[bytes.LastIndexByte(data, ')')+1:]
+	return !bytes.HasPrefix(state, []byte(" Z"))
+}
+
+func TestSuperviseStartsAndStops(t *testing.T) {
+	s := startSupervisor(t, newTestRunner(t, "START='sleep 60'\n"))
+	waitFor(t, "the app to start", func() bool { return strings.Contains(s.logged(), "started installs/web-1") })
+	pid := appPID(s.r.dir)
+	if !alive(pid) {
+		t.Fatalf("app.pid names %d, which isn't running", pid)
+	}
+
+	if err := s.stop(t); err != nil {
+		t.Fatal(err)
+	}
+	if alive(pid) {
+		t.Errorf("app %d still running after stop", pid)
+	}
+	if pid := appPID(s.r.dir); pid != 0 {
+		t.Errorf("app.pid still names %d after stop", pid)
+	}
+	if !strings.Contains(s.logged(), "app stopped") {
+		t.Errorf("node.log doesn't record the app stopping:\n%s", s.logged())
+	}
+}
+
+func TestSuperviseKillsAppIgnoringTerm(t *testing.T) {
+	r := newTestRunner(t, `START="bash -c 'trap \"\" TERM; echo ignoring; while true; do sleep 0.1; done'"`+"\n")
+	r.stopTimeout = 200 * time.Millisecond
+	s := startSupervisor(t, r)
+	waitFor(t, "the app to ignore SIGTERM", func() bool { return strings.Contains(s.logged(), "stdout ignoring") })
+	pid := appPID(r.dir)
+
+	start := time.Now()
+	if err := s.stop(t); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < r.stopTimeout {
+		t.Errorf("stopped after %s, before the stop timeout", elapsed)
+	}
+	if alive(pid) {
+		t.Errorf("app %d still running after SIGKILL", pid)
+	}
+	if !strings.Contains(s.logged(), "app killed") {
+		t.Errorf("node.log doesn't record the app being killed:\n%s", s.logged())
+	}
+}
+
+func TestSuperviseBacksOff(t *testing.T) {
+	defer func(min, max time.Duration) { minRestartDelay, maxRestartDelay = min, max }(minRestartDelay, maxRestartDelay)
+	minRestartDelay, maxRestartDelay = 20*time.Millisecond, 80*time.Millisecond
+
+	s := startSupervisor(t, newTestRunner(t, "START='exit 1'\n"))
+	waitFor(t, "the delay to reach its maximum", func() bool { return strings.Count(s.logged(), "restarting app in 80ms") >= 2 })
+	if err := s.stop(t); err != nil {
+		t.Fatal(err)
+	}
+
+	var delays []string
+	for _, line := range strings.Split(s.logged(), "\n") {
+		if _, delay, ok := strings.Cut(line, "restarting app in "); ok {
+			delays = append(delays, delay)
+		}
+	}
+	if got := strings.Join(delays[:4], " "); got != "20ms 40ms 80ms 80ms" {
+		t.Errorf("restarted after %s, want the delay to double up to 80ms", got)
+	}
+}
+
+func TestSuperviseRestartsOnHangup(t *testing.T) {
+	s := startSupervisor(t, newTestRunner(t, "START='sleep 60'\n"))
+	waitFor(t, "the app to start", func() bool { return appPID(s.r.dir) != 0 })
+	first := appPID(s.r.dir)
+
+	s.signals <- syscall.SIGHUP
+	waitFor(t, "the app to restart", func() bool { pid := appPID(s.r.dir); return pid != 0 && pid != first })
+	if alive(first) {
+		t.Errorf("the first app, %d, is still running", first)
+	}
+}
+
+func TestSuperviseRestartsMidPrepare(t *testing.T) {
+	// A stand-in for nvm whose first `npm install` hangs in a child process,
+	// as a real one would in node-gyp or a postinstall script.
+	nvm := t.TempDir()
+	os.Mkdir(filepath.Join(nvm, "bin"), 0755)
+	os.WriteFile(filepath.Join(nvm, "nvm.sh"), []byte(`export PATH="$NVM_DIR/bin:$PATH"
+nvm() { :; }
+`), 0644)
+	os.WriteFile(filepath.Join(nvm, "bin", "npm"), []byte(`#!/bin/bash
+if [ "$1" != install ]; then
+	exec sleep 60
+fi
+if [ ! -e installed ]; then
+	touch installed
+	sleep 60 &
+	echo $! > install.pid
+	wait
+fi
+`), 0755)
+	t.Setenv("NVM_DIR", nvm)
+
+	s := startSupervisor(t, newTestRunner(t, ""))
+	installPID := filepath.Join(s.r.dir, currentLink, "install.pid")
+	waitFor(t, "npm install to start", func() bool {
+		data, _ := os.ReadFile(installPID)
+		return bytes.HasSuffix(data, []byte("\n"))
+	})
+	data, _ := os.ReadFile(installPID)
+	var pid int
+	fmt.Sscan(string(data), &pid)
+
+	s.signals <- syscall.SIGHUP
+	waitFor(t, "the app to start", func() bool { return appPID(s.r.dir) != 0 })
+	waitFor(t, "the first install's child to be stopped", func() bool { return !alive(pid) })
+	if !strings.Contains(s.logged(), "interrupted by hangup") {
+		t.Errorf("node.log doesn't record the interrupted install:\n%s", s.logged())
+	}
+}
+
+func TestRestartLeavesSystemdSupervisorToItsUnit(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, serverconfig.FileName)
+	os.WriteFile(config, []byte("BUILD=/build/jolli-manager/deploy/dev\nSUPERVISOR=systemd\n"), 0644)
+
+	if err := restartServer(dir); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := runnerPID(dir); err != nil || pid != 0 {
+		t.Errorf("restart started supervisor %d, %v; want none", pid, err)
+	}
+
+	os.WriteFile(config, []byte("SUPERVISOR=pm2\n"), 0644)
+	if err := ensureRunning(dir); err == nil {
+		t.Error("accepted an unknown SUPERVISOR")
+	}
+}
+
+func TestRunnerPIDLeavesLockAlone(t *testing.T) {
+	dir := t.TempDir()
+	if pid, err := runnerPID(dir); err != nil || pid != 0 {
+		t.Fatalf("runnerPID = %d, %v with no supervisor", pid, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, runnerPIDFile)); err == nil {
+		t.Error("probing created run.pid")
+	}
+
+	lock, err := lockRunner(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := runnerPID(dir); err != nil || pid != os.Getpid() {
+		t.Errorf("runnerPID = %d, %v; want %d", pid, err, os.Getpid())
+	}
+	unlockRunner(lock)
+
+	// A probe of an unsupervised server doesn't keep a supervisor from
+	// taking the lock, nor write a PID of its own.
+	if pid, err := runnerPID(dir); err != nil || pid != 0 {
+		t.Errorf("runnerPID = %d, %v after unlocking", pid, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, runnerPIDFile)); len(data) != 0 {
+		t.Errorf("run.pid holds %q after probing", data)
+	}
+	lock, err = lockRunner(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockRunner(lock)
+}
@@ -0,0 +1,245 @@
+// Package serverconfig parses the per-server .config file that tells the node
+// deploy tooling which build to run and which env file to use.
+//
+// The file is a list of KEY=VALUE assignments in a strict subset of shell
+// syntax, so existing files keep working without being evaluated by a shell:
+//
+//	# comments and blank lines are ignored
+//	BUILD=/build/jolli-web/main
+//	export ENV='/home/node/env/prod.env'
+//	HEALTH_URL="http://localhost:8034/api/status/check"
+//
+// Values may be unquoted, single quoted (taken literally) or double quoted
+// (where, as in a shell, \\, \", \$ and \` are the only escapes and any other
+// backslash is kept). A backslash at the end of a line, outside single
+// quotes, continues the value on the next line. Anything a shell
+// would expand or execute, such as $VAR, $(...), backticks, ; or |, is
+// rejected rather than silently passed through.
+package serverconfig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the config file inside a server directory.
+const FileName = ".config"
+
+// ServerConfig is the parsed contents of a server's .config file.
+type ServerConfig struct {
+	// Build is the name of the parameter holding the S3 URL of the build to run.
+	Build string
+	// Env is the path of the env file copied next to the running app.
+	Env string
+	// Extra holds every other key in the file.
+	Extra map[string]string
+}
+
+// Get returns the value of key, including BUILD and ENV, or "" if unset.
+func (c *ServerConfig) Get(key string) string {
+	switch key {
+	case "BUILD":
+		return c.Build
+	case "ENV":
+		return c.Env
+	}
+	return c.Extra[key]
+}
+
+// Int returns the value of key as a positive integer, or fallback if unset.
+func (c *ServerConfig) Int(key string, fallback int) (int, error) {
+	value := c.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive number, got %q", key, value)
+	}
+	return n, nil
+}
+
+// Duration returns the value of key parsed by time.ParseDuration, or
+// fallback if unset.
+func (c *ServerConfig) Duration(key string, fallback time.Duration) (time.Duration, error) {
+	value := c.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 30s, got %q", key, value)
+	}
+	return d, nil
+}
+
+// SyntaxError reports a line that isn't a valid assignment.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Load reads the .config file in a server directory.
+func Load(serverDir string) (*ServerConfig, error) {
+	path := filepath.Join(serverDir, FileName)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse reads a .config file.
+func Parse(r io.Reader) (*ServerConfig, error) {
+	vars, err := ParseVars(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ServerConfig{
+		Build: vars["BUILD"],
+		Env:   vars["ENV"],
+		Extra: vars,
+	}
+	delete(vars, "BUILD")
+	delete(vars, "ENV")
+	return c, nil
+}
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// errContinued reports a value that ends in a backslash, which continues it
+// on the next line.
+var errContinued = errors.New("backslash at end of file")
+
+// ParseVars reads KEY=VALUE assignments in the same syntax as a .config file.
+// Later assignments to a key replace earlier ones.
+func ParseVars(r io.Reader) (map[string]string, error) {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "export "); ok {
+			line = strings.TrimLeft(rest, " \t")
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, &SyntaxError{Line: n, Msg: "expected KEY=VALUE"}
+		}
+		if !keyPattern.MatchString(key) {
+			return nil, &SyntaxError{Line: n, Msg: fmt.Sprintf("invalid key %q", key)}
+		}
+
+		start := n
+		value, err := parseValue(raw)
+		for errors.Is(err, errContinued) && scanner.Scan() {
+			n++
+			raw += "\n" + scanner.Text()
+			value, err = parseValue(raw)
+		}
+		if err != nil {
+			return nil, &SyntaxError{Line: start, Msg: fmt.Sprintf("%s: %s", key, err)}
+		}
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// parseValue unquotes the right-hand side of an assignment. The value may be
+// built from several adjacent quoted and unquoted parts, as in a shell, and
+// may be followed by whitespace and a comment. Backslash-newline pairs are
+// dropped, and errContinued is returned if raw ends in a backslash that
+// would start one.
+func parseValue(raw string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(raw); {
+		switch c := raw[i]; {
+		case c == '\'':
+			end := strings.IndexByte(raw[i+1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated single quote")
+			}
+			b.WriteString(raw[i+1 : i+1+end])
+			i += end + 2
+		case c == '"':
+			n, err := parseDoubleQuoted(raw[i+1:], &b)
+			if err != nil {
+				return "", err
+			}
+			i += n + 2
+		case c == ' ' || c == '\t':
+			if rest := strings.TrimLeft(raw[i:], " \t"); rest != "" && rest[0] != '#' {
+				return "", fmt.Errorf("unexpected text after value: %q", rest)
+			}
+			return b.String(), nil
+		case c == '\\':
+			if i+1 == len(raw) {
+				return "", errContinued
+			}
+			if raw[i+1] != '\n' {
+				b.WriteByte(raw[i+1])
+			}
+			i += 2
+		case strings.IndexByte("$`;&|<>(){}*?[]!~", c) >= 0:
+			return "", fmt.Errorf("unquoted %q is not supported", c)
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+// parseDoubleQuoted reads the inside of a double-quoted string up to its
+// closing quote, returning the number of bytes consumed before the quote.
+func parseDoubleQuoted(s string, b *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return i, nil
+		case '$', '`':
+			return 0, fmt.Errorf("%q inside double quotes is not supported; escape it or use single quotes", c)
+		case '\\':
+			if i+1 == len(s) {
+				return 0, errContinued
+			}
+			i++
+			switch e := s[i]; e {
+			case '\\', '"', '$', '`':
+				b.WriteByte(e)
+			case '\n':
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return 0, fmt.Errorf("unterminated double quote")
+}
@@ -0,0 +1,99 @@
+package serverconfig
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseVars(t *testing.T) {
+	// Each value is what bash assigns for the same line.
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`A=plain`, "plain"},
+		{`export A=exported`, "exported"},
+		{`  A=indented  # comment`, "indented"},
+		{`A=`, ""},
+		{`A=''`, ""},
+		{`A='single $HOME \n "x"'`, `single $HOME \n "x"`},
+		{`A="double 'x' \" \\ \$ \` + "`" + `"`, `double 'x' " \ $ ` + "`"},
+		{`A="kept \n \r \t \a"`, `kept \n \r \t \a`},
+		{`A=esc\ aped\'`, `esc aped'`},
+		{`A='con'cat"enated"`, "concatenated"},
+		{"A=\"multi\\\nline\"", "multiline"},
+		{"A=un\\\nquoted", "unquoted"},
+		{"A=\"first\\\n  second\"", "first  second"},
+		{`A=http://localhost:8034/api/status/check`, "http://localhost:8034/api/status/check"},
+	}
+	for _, tt := range tests {
+		vars, err := ParseVars(strings.NewReader(tt.line + "\n"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if vars["A"] != tt.want {
+			t.Errorf("%s read as %q, want %q", tt.line, vars["A"], tt.want)
+		}
+	}
+}
+
+func TestParseVarsRejects(t *testing.T) {
+	tests := []string{
+		`A=$HOME`,
+		`A=$(id)`,
+		"A=`id`",
+		`A="$HOME"`,
+		`A="$(id)"`,
+		"A=\"`id`\"",
+		`A=x;id`,
+		`A=x|id`,
+		`A=x&&id`,
+		`A=x>out`,
+		`A=*`,
+		`A=x id`,
+		`A='open`,
+		`A="open`,
+		`A=x\`,
+		`A="x\`,
+		`1A=x`,
+		`A-B=x`,
+		`no assignment`,
+	}
+	for _, line := range tests {
+		var syntax *SyntaxError
+		if vars, err := ParseVars(strings.NewReader(line + "\n")); !errors.As(err, &syntax) {
+			t.Errorf("%s read as %q, %v; want a syntax error", line, vars, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	config := `# comments and blank lines are ignored
+
+BUILD=/build/jolli-web/main
+export ENV='/home/node/env/prod.env'
+HEALTH_URL="http://localhost:8034/api/status/check"
+KEEP=5
+KEEP=2
+`
+	c, err := Parse(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Build != "/build/jolli-web/main" || c.Env != "/home/node/env/prod.env" {
+		t.Errorf("got BUILD %q and ENV %q", c.Build, c.Env)
+	}
+	if got := c.Get("HEALTH_URL"); got != "http://localhost:8034/api/status/check" {
+		t.Errorf("HEALTH_URL = %q", got)
+	}
+	if keep, err := c.Int("KEEP", 3); keep != 2 || err != nil {
+		t.Errorf("KEEP = %d, %v; want the last assignment", keep, err)
+	}
+
+	var syntax *SyntaxError
+	if _, err := Parse(strings.NewReader("A=1\nB=\"two\\\nlines\"\nC=$x\n")); !errors.As(err, &syntax) || syntax.Line != 4 {
+		t.Errorf("got %v, want a syntax error on line 4", err)
+	}
+}
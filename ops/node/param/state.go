@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// stateFile holds what param has deployed to a server directory, so a restart
+// of the sync daemon doesn't redeploy builds that are already installed.
+const stateFile = ".deploy.json"
+
+// deployState is the persisted deploy record for one server directory.
+type deployState struct {
+	// URL is the build URL that was last deployed.
+	URL string `json:"url,omitempty"`
+	// Current is the install, relative to the server directory, that current
+	// points at.
+	Current string `json:"current,omitempty"`
+	// Good is the most recent install that passed its health check, or that
+	// started if the server has no health check.
+	Good string `json:"good,omitempty"`
+	// Previous is the good install that ran before Good, which
+	// `param rollback` returns to.
+	Previous string `json:"previous,omitempty"`
+}
+
+func loadState(dir string) (deployState, error) {
+	var state deployState
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	return state, json.Unmarshal(data, &state)
+}
+
+func saveState(dir string, state deployState) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, stateFile), append(data, '\n'), 0644)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"jolli.ai/param/serverconfig"
+)
+
+// retryFailedAfter is how long the sync daemon waits before retrying a build
+// that failed to deploy, so a broken build isn't fetched again every poll.
+const retryFailedAfter = time.Minute
+
+// readTimeout bounds each poll's read of a BUILD parameter, retries
+// included, so a hung credential fetch or SSM call can't wedge the daemon.
+const readTimeout = 30 * time.Second
+
+// syncer polls each server's BUILD parameter and deploys new builds.
+type syncer struct {
+	// ssmSvcs reads BUILD parameters from the primary region, then from
+	// each fallback region in turn while the primary is unavailable.
+	ssmSvcs  []*ssm.Client
+	deployer *deployer
+	servers  string
+
+	// failed records builds that failed to deploy, why, and when to retry
+	// them, keyed by server directory. An entry stays until the server's
+	// BUILD changes or the build deploys, so a server that is waiting to
+	// retry, or that was rolled back, keeps reporting the failure.
+	failed map[string]failedDeploy
+
+	// reported records the class of the last error logged for each server
+	// directory. A server that can't be synced, because its .config is
+	// broken or its BUILD parameter is missing, unreadable or unreachable,
+	// fails the same way every poll, so an error is logged when its class
+	// changes and when it recovers rather than every second.
+	reported map[string]errorClass
+
+	// cache backs the BUILD reads, so after a reboot during an SSM outage
+	// servers still start the build they last ran.
+	cache *paramCache
+}
+
+type failedDeploy struct {
+	url     string
+	err     error
+	retryAt time.Time
+}
+
+// syncServers watches every server directory and deploys the build its BUILD
+// parameter points at whenever that changes. It replaces the sync.sh loop.
+func syncServers(ctx context.Context, args []string) error {
+	const syncUsage = "param sync [--region <region>] [--servers <dir>] [--interval <duration>] [--keep <n>] [--install-timeout <duration>] [--health-timeout <duration>] [--max-stale <duration>] [--fallback-regions <region>,...]"
+
+	fs := newFlagSet("sync")
+	region := fs.String("region", "", "AWS region")
+	servers := fs.String("servers", "/home/node/servers", "directory holding one directory per server")
+	interval := fs.Duration("interval", time.Second, "time between polls")
+	keep := fs.Int("keep", defaultKeep, "number of installs to keep, besides current and the rollback target")
+	installTimeout := fs.Duration("install-timeout", defaultInstallTimeout, "time a restarted server has to install dependencies and start its new app")
+	healthTimeout := fs.Duration("health-timeout", defaultHealthTimeout, "time a new install has to pass its health check once its app has started")
+	maxStale := cacheFlag(fs)
+	fallbacks := fallbackFlag(fs)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 || *keep < 1 {
+		return usageError(syncUsage)
+	}
+
+	cfg, err := loadSyncConfig(ctx, *region)
+	if err != nil {
+		return stopped(ctx)
+	}
+	ssmSvcs, err := newSSMClients(ctx, cfg.Region, *fallbacks)
+	if err != nil {
+		return err
+	}
+
+	s := &syncer{
+		ssmSvcs:  ssmSvcs,
+		deployer: &deployer{s3Svc: newS3(cfg), keep: *keep, installTimeout: *installTimeout, healthTimeout: *healthTimeout},
+		servers:  *servers,
+		failed:   map[string]failedDeploy{},
+		reported: map[string]errorClass{},
+		cache:    openCache(*maxStale),
+	}
+	for {
+		s.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return stopped(ctx)
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// loadSyncConfig loads the AWS configuration for the sync daemon, retrying
+// with backoff until it succeeds. It only fails once ctx is done. Without
+// --region the region comes from instance metadata, which may not answer yet
+// early in boot, and the daemon is started only once, so giving up would
+// leave the node without deploys until it is rebooted.
+func loadSyncConfig(ctx context.Context, region string) (aws.Config, error) {
+	delay := time.Second
+	for {
+		cfg, err := loadAWSConfig(ctx, region)
+		if err == nil {
+			return cfg, nil
+		}
+		log.Printf("%v; retrying in %s", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return cfg, ctx.Err()
+		case <-time.After(delay):
+			delay = min(delay*2, time.Minute)
+		}
+	}
+}
+
+// poll checks every server directory once.
+func (s *syncer) poll(ctx context.Context) {
+	dirs, err := filepath.Glob(filepath.Join(s.servers, "*"))
+	if err != nil {
+		log.Printf("listing servers: %v", err)
+		return
+	}
+
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, serverconfig.FileName)); err != nil {
+			continue
+		}
+
+		err := s.syncServer(ctx, dir)
+		class, last := classify(err), s.reported[dir]
+		switch {
+		case err == nil && last != 0:
+			log.Printf("%s: recovered", dir)
+		case err != nil && class != last:
+			log.Printf("%s: %v (%s)", dir, err, class)
+		}
+		if err == nil {
+			delete(s.reported, dir)
+		} else {
+			s.reported[dir] = class
+		}
+	}
+}
+
+// syncServer deploys the build a server's BUILD parameter names, unless it is
+// already deployed or recently failed. A server whose build is already
+// deployed gets a supervisor if it has none, so apps come back after a reboot.
+// Until a failed build is retried, or if it was rolled back, its error is
+// returned again.
+func (s *syncer) syncServer(ctx context.Context, dir string) error {
+	cfg, err := serverconfig.Load(dir)
+	if err != nil || cfg.Build == "" {
+		return err
+	}
+
+	readCtx, cancel := context.WithTimeout(ctx, readTimeout)
+	param, err := cachedParameter(readCtx, s.cache, s.ssmSvcs, cfg.Build, false)
+	cancel()
+	if err != nil {
+		return err
+	}
+	url := *param.Value
+
+	state, err := loadState(dir)
+	if err != nil {
+		return fmt.Errorf("reading deploy state: %w", err)
+	}
+	f, failed := s.failed[dir]
+	if failed && f.url != url {
+		delete(s.failed, dir)
+		failed = false
+	}
+	if state.URL == url {
+		// A build that was rolled back keeps its URL in the state, so it
+		// isn't deployed again until BUILD changes.
+		if err := ensureRunning(dir); err != nil || !failed {
+			return err
+		}
+		return f.err
+	}
+	if failed && time.Now().Before(f.retryAt) {
+		return f.err
+	}
+
+	log.Printf("%s: deploying %s from %s version %d", dir, url, aws.ToString(param.Name), param.Version)
+	if err := s.deployer.deploy(ctx, dir, url); err != nil {
+		err = fmt.Errorf("deploying %s: %w", url, err)
+		s.failed[dir] = failedDeploy{url: url, err: err, retryAt: time.Now().Add(retryFailedAfter)}
+		return err
+	}
+	delete(s.failed, dir)
+
+	log.Printf("%s: deployed %s", dir, url)
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"jolli.ai/param/serverconfig"
+)
+
+func TestPollReportsEachFailureOnce(t *testing.T) {
+	srv := newFakeAWS(t)
+	d := putBuilds(t, srv)
+	srv.SetParameter("/build/jolli-web/main", "String", "s3://builds/missing.tgz")
+	ssmSvcs, err := newSSMClients(context.Background(), "us-west-2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers := t.TempDir()
+	for name, config := range map[string]string{
+		"web":    "BUILD=/build/jolli-web/main\n",
+		"broken": "BUILD=$BUILD\n",
+	} {
+		os.Mkdir(filepath.Join(servers, name), 0755)
+		os.WriteFile(filepath.Join(servers, name, serverconfig.FileName), []byte(config), 0644)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s := &syncer{
+		ssmSvcs:  ssmSvcs,
+		deployer: d,
+		servers:  servers,
+		failed:   map[string]failedDeploy{},
+		reported: map[string]errorClass{},
+	}
+	for range 3 {
+		s.poll(context.Background())
+	}
+
+	out := logged.String()
+	if n := strings.Count(out, "deploying s3://builds/missing.tgz:"); n != 1 {
+		t.Errorf("logged the failed deploy %d times:\n%s", n, out)
+	}
+	if n := strings.Count(out, "broken: "); n != 1 {
+		t.Errorf("logged the broken .config %d times:\n%s", n, out)
+	}
+	if strings.Contains(out, "recovered") {
+		t.Errorf("reported a recovery while the deploy is still failing:\n%s", out)
+	}
+}
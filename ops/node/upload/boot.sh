@@ -2,6 +2,22 @@
 
 /usr/sbin/sysctl -p
 
+# Search the internal domain of the region the instance runs in, so names
+# such as instance-data resolve. us-east-1 predates the region-named domains.
+until REGION=$(/usr/local/bin/param region); do
+	sleep 1;
+done
+DOMAIN="${REGION}.compute.internal"
+if [[ ${REGION} == us-east-1 ]]; then
+	DOMAIN=ec2.internal
+fi
+mkdir -p /etc/systemd/resolved.conf.d
+cat << EOF > /etc/systemd/resolved.conf.d/region.conf
+[Resolve]
+Domains=${DOMAIN}
+EOF
+systemctl restart systemd-resolved
+
 until [[ $(curl -o /tmp/user-data -s -w %\{http_code\} http://instance-data/latest/user-data) == 200 ]]; do
 	sleep 1;
 done
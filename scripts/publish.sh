@@ -1,29 +1,12 @@
 #!/bin/bash
 set -e
 
-BUCKET="jolli-builds"
-BRANCH=$(git rev-parse --abbrev-ref HEAD)
+# Builds param and publishes each dist directory's package: the tarball named
+# in its package.json is uploaded to s3://jolli-builds/{name}/{version}/{package}
+# with a .sha256 sidecar, and /build/{name}/{branch} is pointed at it.
 
-# Publish jolli-web
-NAME=$(jq -r '.name' dist/package.json)
-VERSION=$(jq -r '.version' dist/package.json)
-PACKAGE=$(jq -r '.package' dist/package.json)
+PARAM=$(mktemp)
+trap 'rm -f "$PARAM"' EXIT
+(cd ops/node/param && go build -o "$PARAM")
 
-KEY="$NAME/$VERSION/$PACKAGE"
-DEST="s3://$BUCKET/$KEY"
-PARAM="/build/$NAME/$BRANCH"
-
-aws s3api head-object --bucket "$BUCKET" --key "$KEY" 2> /dev/null || aws s3 cp "$PACKAGE" "$DEST"
-aws ssm put-parameter --name "$PARAM" --value "$DEST" --type String --overwrite
-
-# Publish jolli-manager
-MANAGER_NAME=$(jq -r '.name' manager-dist/package.json)
-MANAGER_VERSION=$(jq -r '.version' manager-dist/package.json)
-MANAGER_PACKAGE=$(jq -r '.package' manager-dist/package.json)
-
-MANAGER_KEY="$MANAGER_NAME/$MANAGER_VERSION/$MANAGER_PACKAGE"
-MANAGER_DEST="s3://$BUCKET/$MANAGER_KEY"
-MANAGER_PARAM="/build/$MANAGER_NAME/$BRANCH"
-
-aws s3api head-object --bucket "$BUCKET" --key "$MANAGER_KEY" 2> /dev/null || aws s3 cp "$MANAGER_PACKAGE" "$MANAGER_DEST"
-aws ssm put-parameter --name "$MANAGER_PARAM" --value "$MANAGER_DEST" --type String --overwrite
+"$PARAM" publish --bucket jolli-builds --dist dist --dist manager-dist